@@ -19,6 +19,11 @@ var addCmd = &cobra.Command{
             if [directory] is not set, it defaults to label
               --count is the number of episodes to keep on disk, defaults to config if 0
               --direction is "oldest" or "newest" and dictates the order of episodes to download
+              --tag tags the podcast, may be repeated or comma separated
+              --mode is "auto", "manual" or "archive", manual podcasts only download queued
+                episodes and archived podcasts download every episode
+              --prune removes the oldest downloads beyond the count to keep so newer episodes
+                are downloaded, use with --direction newest
             
             example:
                castigate add 5_minutes https://5minutesinchurchhistory.ligonier.org/rss`,
@@ -37,6 +42,38 @@ func runAddCmd(cmd *cobra.Command, args []string) {
 	if err != nil {
 		log.Fatalf("could not parse --direction flag: %v", err)
 	}
+	tags, err := cmd.Flags().GetStringSlice("tag")
+	if err != nil {
+		log.Fatalf("could not parse --tag flag: %v", err)
+	}
+	mode, err := cmd.Flags().GetString("mode")
+	if err != nil {
+		log.Fatalf("could not parse --mode flag: %v", err)
+	}
+	err = feed.ValidateMode(mode)
+	if err != nil {
+		log.Fatalf("could not parse --mode flag: %v", err)
+	}
+	prune, err := cmd.Flags().GetBool("prune")
+	if err != nil {
+		log.Fatalf("could not parse --prune flag: %v", err)
+	}
+	enclosures, err := cmd.Flags().GetStringSlice("enclosure")
+	if err != nil {
+		log.Fatalf("could not parse --enclosure flag: %v", err)
+	}
+	err = feed.ValidateEnclosurePreference(enclosures)
+	if err != nil {
+		log.Fatalf("could not parse --enclosure flag: %v", err)
+	}
+	identity, err := cmd.Flags().GetString("identity")
+	if err != nil {
+		log.Fatalf("could not parse --identity flag: %v", err)
+	}
+	err = feed.ValidateIdentity(identity)
+	if err != nil {
+		log.Fatalf("could not parse --identity flag: %v", err)
+	}
 	label := args[0]
 	url := args[1]
 	directory := label
@@ -51,14 +88,20 @@ func runAddCmd(cmd *cobra.Command, args []string) {
 	}
 
 	log.Infof("adding podcast: %s with feed %s to %s directory", label, url, directory)
-	config.Podcasts = append(config.Podcasts, &feed.Podcast{
-		Label:       label,
-		Feed:        url,
-		Directory:   directory,
-		CountToKeep: count,
-		Start:       direction,
-		Episodes:    make(map[string]*feed.Episode, 0),
-	})
+	podcast = &feed.Podcast{
+		Label:               label,
+		Feed:                url,
+		Directory:           directory,
+		CountToKeep:         count,
+		Start:               direction,
+		Mode:                mode,
+		Prune:               prune,
+		EnclosurePreference: enclosures,
+		Identity:            identity,
+		Episodes:            make(map[string]*feed.Episode, 0),
+	}
+	podcast.AddTags(tags...)
+	config.Podcasts = append(config.Podcasts, podcast)
 	backend.Save(config)
 }
 
@@ -66,5 +109,10 @@ func init() {
 	rootCmd.AddCommand(addCmd)
 	addCmd.Flags().IntP("count", "o", 0, "number of episodes to keep on disk, default is 0 which honors the master config default")
 	addCmd.Flags().StringP("direction", "r", "oldest", "order of podcasts, 'oldest' or 'newest'")
+	addCmd.Flags().StringSlice("tag", nil, "tags for the podcast, used to select podcasts to sync or list")
+	addCmd.Flags().Bool("prune", false, "remove the oldest downloads beyond the count to keep")
+	addCmd.Flags().String("mode", feed.ModeAuto, "auto downloads new episodes, manual only queued episodes, archive every episode")
+	addCmd.Flags().String("identity", "", "how episodes are identified, guid, url, podcast-guid or title-date, default guid")
+	addCmd.Flags().StringSlice("enclosure", nil, "enclosure preference by MIME type, codec=, bitrate<= or host=, most preferred first, !video/* never downloads video")
 
 }
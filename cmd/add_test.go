@@ -134,3 +134,26 @@ func TestAddNew(t *testing.T) {
 		t.Fatalf("expected podcast count to be 2, got %d", podcast.CountToKeep)
 	}
 }
+
+func TestAddTags(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(addCmd)
+
+	rootCmd.SetArgs([]string{"--config", fn, "add", "--tag", "kids,short", "--tag", "kids", "test", "http://feed.example.com"})
+	err := rootCmd.Execute()
+	if err != nil {
+		t.Fatalf("error adding podcast test: %v", err)
+	}
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	podcast := config.Podcasts[0]
+	if len(podcast.Tags) != 2 || !podcast.HasTag("kids") || !podcast.HasTag("short") {
+		t.Fatalf("expected tags kids and short, got %v", podcast.Tags)
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright © 2023 Daniel Blezek <blezek.daniel@mayo.edu>
+This file is part of a CLI application.
+*/
+package cmd
+
+import (
+	"castigate/feed"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"path/filepath"
+)
+
+// dedupeCmd represents the dedupe command
+var dedupeCmd = &cobra.Command{
+	Use:   "dedupe <label>",
+	Short: "merge duplicate episodes",
+	Long: `Merge the episodes of a podcast that are the same episode under its identity
+strategy, for instance episodes created again when the feed changed the tracking
+parameters of its enclosure URLs.  The episode already downloaded is kept, the
+files of other downloaded duplicates are removed, or moved to the trash directory.
+  --identity is guid, url, podcast-guid or title-date, the podcast's own strategy by default
+  --dry-run prints the duplicates without changing anything`,
+	Args: cobra.ExactArgs(1),
+	Run:  runDedupeCmd,
+}
+
+func runDedupeCmd(cmd *cobra.Command, args []string) {
+	backend, config := LoadConfiguration(cmd)
+
+	label := args[0]
+	podcast, err := config.FindPodcast(label)
+	if err != nil {
+		log.Fatalf("could not find podcast with label %s: %v", label, err)
+	}
+	identity, err := cmd.Flags().GetString("identity")
+	if err != nil {
+		log.Fatalf("could not parse --identity flag: %v", err)
+	}
+	err = feed.ValidateIdentity(identity)
+	if err != nil {
+		log.Fatalf("could not parse --identity flag: %v", err)
+	}
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		log.Fatalf("could not parse --dry-run flag: %v", err)
+	}
+
+	configFilePath := filepath.Dir(backend.Filename)
+	out := cmd.OutOrStdout()
+	verb := "merged"
+	if dryRun {
+		verb = "would merge"
+	}
+	merged := 0
+	for _, group := range podcast.DuplicateEpisodes(identity) {
+		keeper := group[0]
+		fmt.Fprintf(out, "  keeping %s %s %q\n", keeper.ShortID(), keeper.State, keeper.Title)
+		for _, duplicate := range group[1:] {
+			fmt.Fprintf(out, "    %s %s %s %q\n", verb, duplicate.ShortID(), duplicate.State, duplicate.Title)
+			if !dryRun {
+				podcast.MergeDuplicate(config, configFilePath, keeper, duplicate)
+			}
+			merged++
+		}
+	}
+	fmt.Fprintf(out, "%s: %s %d duplicate episodes\n", podcast.Label, verb, merged)
+
+	if dryRun || merged == 0 {
+		return
+	}
+	err = backend.Save(config)
+	if err != nil {
+		log.Fatalf("error saving config: %v", err)
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(dedupeCmd)
+	dedupeCmd.Flags().String("identity", "", "identity strategy to find duplicates with, guid, url, podcast-guid or title-date")
+	dedupeCmd.Flags().Bool("dry-run", false, "print the duplicates without changing anything")
+}
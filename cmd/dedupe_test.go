@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"castigate/feed"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDedupe(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(syncCmd)
+	defer ResetFlags(editCmd)
+	defer ResetFlags(dedupeCmd)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	// items without a GUID, and a tracking parameter that changes every fetch
+	fetches := 0
+	mux.HandleFunc("/rss", func(res http.ResponseWriter, req *http.Request) {
+		fetches++
+		rss := `<?xml version="1.0" encoding="UTF-8"?><rss version="2.0"><channel><title>dedupe</title>`
+		for day := 1; day <= 3; day++ {
+			rss += fmt.Sprintf(`<item><title>episode %d</title><pubDate>Wed, 0%d Jan 2020 10:00:00 GMT</pubDate>`+
+				`<enclosure url="%s/asset/episode-%d.mp3?t=%d" length="0" type="audio/mpeg"/></item>`, day, day, ts.URL, day, fetches)
+		}
+		res.Write([]byte(rss + `</channel></rss>`))
+	})
+	mux.HandleFunc("/", func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "audio/mpeg")
+		res.Write([]byte(testAsset))
+	})
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheDirectory = ""
+	config.Podcasts = append(config.Podcasts, &feed.Podcast{
+		Label:       "dedupe",
+		Feed:        ts.URL + "/rss",
+		Directory:   dir,
+		CountToKeep: 2,
+		Start:       "oldest",
+		Episodes:    make(map[string]*feed.Episode, 0),
+	})
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	RunSync(t, fn, &backend, "dedupe")
+	podcast, _ := RunSync(t, fn, &backend, "dedupe")
+	if len(podcast.Episodes) != 6 {
+		t.Fatalf("expected the changed URLs to duplicate the episodes, got %d episodes", len(podcast.Episodes))
+	}
+
+	output := RunCommand(t, fn, "dedupe", "dedupe", "--identity", "url", "--dry-run")
+	podcast, _ = LoadPodcast(t, &backend, "dedupe")
+	if len(podcast.Episodes) != 6 || !strings.Contains(output, "would merge 3 duplicate episodes") {
+		t.Errorf("expected a dry run to change nothing, got %d episodes and %q", len(podcast.Episodes), output)
+	}
+
+	RunCommand(t, fn, "edit", "dedupe", "--identity", feed.IdentityURL)
+	output = RunCommand(t, fn, "dedupe", "dedupe")
+	podcast, _ = LoadPodcast(t, &backend, "dedupe")
+	if len(podcast.Episodes) != 3 || !strings.Contains(output, "merged 3 duplicate episodes") {
+		t.Fatalf("expected 3 episodes after merging, got %d and %q", len(podcast.Episodes), output)
+	}
+	if podcast.GetStateCount(feed.Downloaded) != 2 {
+		t.Errorf("expected the downloaded episodes to be kept, got %d downloaded", podcast.GetStateCount(feed.Downloaded))
+	}
+
+	// the identity matches the feed's items to the episodes despite the new URLs
+	podcast, _ = RunSync(t, fn, &backend, "dedupe")
+	if len(podcast.Episodes) != 3 {
+		t.Errorf("expected no new episodes, got %d", len(podcast.Episodes))
+	}
+	for _, episode := range podcast.Episodes {
+		if !strings.HasSuffix(episode.URL, fmt.Sprintf("?t=%d", fetches)) || episode.Replaced {
+			t.Errorf("expected %s to follow the tracking parameter, got %s", episode.Title, episode.URL)
+		}
+	}
+}
@@ -5,6 +5,7 @@ This file is part of a CLI application.
 package cmd
 
 import (
+	"castigate/feed"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/spf13/cobra"
@@ -14,8 +15,11 @@ import (
 var editCmd = &cobra.Command{
 	Use:   "edit",
 	Short: "edit a podcast",
-	Long: `The edit command supports changing the feed URL, directory, start direction
-the count to keep, and resetting all the episodes to a given state.`,
+	Long: `The edit command supports changing the feed URL, accepting a moved feed, the
+directory, start direction, the count to keep, the mode, pruning, the replaced
+audio policy, the enclosure preference, the identity strategy and the tags.
+Use "castigate episodes mark" to change the state of episodes, for instance to
+reset all the episodes to a given state.`,
 	Args: cobra.ExactArgs(1),
 	Run:  runEditCmd,
 }
@@ -34,7 +38,18 @@ func runEditCmd(cmd *cobra.Command, args []string) {
 		log.Fatalf("could not get url flag %v", err)
 	}
 	if url != "" {
-		podcast.Feed = url
+		podcast.SetFeed(url, "edited")
+	}
+	acceptFeedMove, err := cmd.Flags().GetBool("accept-feed-move")
+	if err != nil {
+		log.Fatalf("could not get accept-feed-move flag %v", err)
+	}
+	if acceptFeedMove {
+		log.Infof("moving the feed of %s to %s", label, podcast.PendingFeed)
+		err = podcast.AcceptFeedMove()
+		if err != nil {
+			log.Fatalf("could not accept the feed move: %v", err)
+		}
 	}
 
 	count, err := cmd.Flags().GetInt("count")
@@ -64,6 +79,68 @@ func runEditCmd(cmd *cobra.Command, args []string) {
 			podcast.Start = "newest"
 		}
 	}
+	mode, err := cmd.Flags().GetString("mode")
+	if err != nil {
+		log.Fatalf("could not get mode flag %v", err)
+	}
+	if mode != "" {
+		err = feed.ValidateMode(mode)
+		if err != nil {
+			log.Fatalf("could not get mode flag %v", err)
+		}
+		podcast.Mode = mode
+	}
+	replacedAudio, err := cmd.Flags().GetString("replaced-audio")
+	if err != nil {
+		log.Fatalf("could not get replaced-audio flag %v", err)
+	}
+	if replacedAudio != "" {
+		err = feed.ValidateReplacedAudio(replacedAudio)
+		if err != nil {
+			log.Fatalf("could not get replaced-audio flag %v", err)
+		}
+		podcast.ReplacedAudio = replacedAudio
+	}
+	identity, err := cmd.Flags().GetString("identity")
+	if err != nil {
+		log.Fatalf("could not get identity flag %v", err)
+	}
+	if identity != "" {
+		err = feed.ValidateIdentity(identity)
+		if err != nil {
+			log.Fatalf("could not get identity flag %v", err)
+		}
+		podcast.Identity = identity
+	}
+	if cmd.Flags().Changed("enclosure") {
+		enclosures, err := cmd.Flags().GetStringSlice("enclosure")
+		if err != nil {
+			log.Fatalf("could not get enclosure flag %v", err)
+		}
+		err = feed.ValidateEnclosurePreference(enclosures)
+		if err != nil {
+			log.Fatalf("could not get enclosure flag %v", err)
+		}
+		podcast.EnclosurePreference = enclosures
+	}
+	if cmd.Flags().Changed("prune") {
+		podcast.Prune, err = cmd.Flags().GetBool("prune")
+		if err != nil {
+			log.Fatalf("could not get prune flag %v", err)
+		}
+	}
+	addTags, err := cmd.Flags().GetStringSlice("add-tag")
+	if err != nil {
+		log.Fatalf("could not get add-tag flag %v", err)
+	}
+	podcast.AddTags(addTags...)
+
+	removeTags, err := cmd.Flags().GetStringSlice("remove-tag")
+	if err != nil {
+		log.Fatalf("could not get remove-tag flag %v", err)
+	}
+	podcast.RemoveTags(removeTags...)
+
 	log.Infof("saving configuration")
 	backend.Save(config)
 }
@@ -71,7 +148,15 @@ func runEditCmd(cmd *cobra.Command, args []string) {
 func init() {
 	rootCmd.AddCommand(editCmd)
 	editCmd.Flags().String("url", "", "URL of the podcast")
+	editCmd.Flags().Bool("accept-feed-move", false, "follow the feed to where sync found it moved, when confirmfeedmoves is set")
 	editCmd.Flags().Int("count", -1, "Number of episodes to keep on disk")
 	editCmd.Flags().String("directory", "", "Directory of the podcast")
 	editCmd.Flags().String("start", "", "download starting with oldest or newest")
+	editCmd.Flags().String("mode", "", "auto downloads new episodes, manual only queued episodes, archive every episode")
+	editCmd.Flags().Bool("prune", false, "remove the oldest downloads beyond the count to keep, --prune=false to stop")
+	editCmd.Flags().String("replaced-audio", "", "keep the downloaded file or redownload episodes whose audio is replaced in the feed")
+	editCmd.Flags().String("identity", "", "how episodes are identified, guid, url, podcast-guid or title-date")
+	editCmd.Flags().StringSlice("enclosure", nil, "enclosure preference by MIME type, codec=, bitrate<= or host=, most preferred first, --enclosure= for the default")
+	editCmd.Flags().StringSlice("add-tag", nil, "tags to add to the podcast")
+	editCmd.Flags().StringSlice("remove-tag", nil, "tags to remove from the podcast")
 }
@@ -57,3 +57,42 @@ func TestEdit(t *testing.T) {
 		t.Fatalf("expected foo directory, got %s", podcast.Directory)
 	}
 }
+
+func TestEditTags(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(editCmd)
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Podcasts = append(config.Podcasts, &feed.Podcast{
+		Label:    "test",
+		Feed:     "http://feed.example.com",
+		Tags:     []string{"news", "long"},
+		Episodes: make(map[string]*feed.Episode, 0),
+	})
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ResetFlags(editCmd)
+	rootCmd.SetArgs([]string{"--config", fn, "edit", "test", "--add-tag", "kids", "--remove-tag", "long"})
+	err = rootCmd.Execute()
+	if err != nil {
+		t.Fatalf("error editing podcast test: %v", err)
+	}
+
+	config, err = backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	podcast := config.Podcasts[0]
+	if len(podcast.Tags) != 2 || podcast.Tags[0] != "news" || podcast.Tags[1] != "kids" {
+		t.Fatalf("expected tags news and kids, got %v", podcast.Tags)
+	}
+}
@@ -0,0 +1,290 @@
+/*
+Copyright © 2023 Daniel Blezek <blezek.daniel@mayo.edu>
+This file is part of a CLI application.
+*/
+package cmd
+
+import (
+	"castigate/feed"
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// episodesCmd represents the episodes command
+var episodesCmd = &cobra.Command{
+	Use:   "episodes <label>",
+	Short: "list the episodes of a podcast",
+	Long: `List the episodes of a podcast with their short ID, date, state, size,
+duration, season and episode number, title and filename, JSON and CSV include the
+rest of the details saved from the feed.  The short ID may be used in place of the GUID by other commands.
+Every episode is listed unless selected by:
+  --since and --until select episodes by date, 2006-01-02 or RFC 3339, inclusive
+  --title selects episodes whose title matches a regular expression
+  --guid selects episodes by GUID or short ID
+  --state selects episodes in one of the states
+  --sort is "order" (the download order), "date", "title", "state", "size" or "duration"
+  --reverse reverses the sort
+  --output is "table", "json" or "csv"
+The state of episodes is changed with "castigate episodes mark".`,
+	Args: cobra.ExactArgs(1),
+	Run:  runEpisodesCmd,
+}
+
+type episodeRow struct {
+	ID       string    `json:"id"`
+	GUID     string    `json:"guid"`
+	Date     time.Time `json:"date"`
+	State    string    `json:"state"`
+	Size     int64     `json:"size"`
+	Title    string    `json:"title"`
+	Filename string    `json:"filename"`
+	// details from the feed
+	Duration    time.Duration `json:"duration,omitempty"`
+	Season      int           `json:"season,omitempty"`
+	Number      int           `json:"episode,omitempty"`
+	EpisodeType string        `json:"episodeType,omitempty"`
+	Explicit    bool          `json:"explicit,omitempty"`
+	Type        string        `json:"type,omitempty"`
+	Link        string        `json:"link,omitempty"`
+	Image       string        `json:"image,omitempty"`
+	Description string        `json:"description,omitempty"`
+}
+
+func runEpisodesCmd(cmd *cobra.Command, args []string) {
+	backend, config := LoadConfiguration(cmd)
+
+	label := args[0]
+	podcast, err := config.FindPodcast(label)
+	if err != nil {
+		log.Fatalf("could not find podcast with label %s: %v", label, err)
+	}
+	sortBy, err := cmd.Flags().GetString("sort")
+	if err != nil {
+		log.Fatalf("could not parse --sort flag: %v", err)
+	}
+	reverse, err := cmd.Flags().GetBool("reverse")
+	if err != nil {
+		log.Fatalf("could not parse --reverse flag: %v", err)
+	}
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		log.Fatalf("could not parse --output flag: %v", err)
+	}
+	if output != "table" && output != "json" && output != "csv" {
+		log.Fatalf("unknown output format %s, expected table, json or csv", output)
+	}
+	selector := episodeSelector(cmd, "state")
+	if selector.IsEmpty() {
+		selector.All = true
+	}
+
+	directory := podcast.ResolveDirectory(filepath.Dir(backend.Filename))
+	rows := make([]episodeRow, 0)
+	for _, episode := range podcast.SelectEpisodes(selector) {
+		row := episodeRow{
+			ID:       episode.ShortID(),
+			GUID:     episode.GUID,
+			Date:     episode.Date,
+			State:    episode.State.String(),
+			Size:     episode.Length,
+			Title:    episode.Title,
+			Filename: episode.Filename,
+
+			Duration:    episode.Duration,
+			Season:      episode.Season,
+			Number:      episode.Number,
+			EpisodeType: episode.EpisodeType,
+			Explicit:    episode.Explicit,
+			Type:        episode.Type,
+			Link:        episode.Link,
+			Image:       episode.Image,
+			Description: episode.Description,
+		}
+		// the size on disk is more accurate than the feed's
+		info, err := os.Stat(filepath.Join(directory, episode.Filename))
+		if err == nil && episode.OnDisk() {
+			row.Size = info.Size()
+		}
+		rows = append(rows, row)
+	}
+	sortEpisodeRows(rows, sortBy)
+	if reverse {
+		slices.Reverse(rows)
+	}
+
+	out := cmd.OutOrStdout()
+	switch output {
+	case "json":
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(rows)
+	case "csv":
+		writer := csv.NewWriter(out)
+		writer.Write([]string{"id", "guid", "date", "state", "size", "title", "filename",
+			"duration", "season", "episode", "episodetype", "explicit", "type", "link", "image", "description"})
+		for _, row := range rows {
+			writer.Write([]string{row.ID, row.GUID, row.Date.Format(time.RFC3339), row.State,
+				strconv.FormatInt(row.Size, 10), row.Title, row.Filename,
+				strconv.Itoa(int(row.Duration.Seconds())), strconv.Itoa(row.Season), strconv.Itoa(row.Number),
+				row.EpisodeType, strconv.FormatBool(row.Explicit), row.Type, row.Link, row.Image, row.Description})
+		}
+		writer.Flush()
+		err = writer.Error()
+	default:
+		writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(writer, "ID\tDATE\tSTATE\tSIZE\tDURATION\tEPISODE\tTITLE\tFILENAME\n")
+		for _, row := range rows {
+			fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", row.ID, row.Date.Format(time.DateOnly), row.State,
+				formatSize(row.Size), formatDuration(row.Duration), formatEpisodeNumber(row.Season, row.Number), row.Title, row.Filename)
+		}
+		err = writer.Flush()
+	}
+	if err != nil {
+		log.Fatalf("could not write episodes: %v", err)
+	}
+}
+
+// sortEpisodeRows sorts the rows, which are in download order, by sortBy.
+func sortEpisodeRows(rows []episodeRow, sortBy string) {
+	var compare func(a, b episodeRow) int
+	switch sortBy {
+	case "order":
+		return
+	case "date":
+		compare = func(a, b episodeRow) int { return a.Date.Compare(b.Date) }
+	case "title":
+		compare = func(a, b episodeRow) int { return strings.Compare(a.Title, b.Title) }
+	case "state":
+		compare = func(a, b episodeRow) int { return strings.Compare(a.State, b.State) }
+	case "size":
+		compare = func(a, b episodeRow) int { return cmp.Compare(a.Size, b.Size) }
+	case "duration":
+		compare = func(a, b episodeRow) int { return cmp.Compare(a.Duration, b.Duration) }
+	default:
+		log.Fatalf("unknown sort %s, expected order, date, title, state, size or duration", sortBy)
+	}
+	slices.SortStableFunc(rows, compare)
+}
+
+// formatSize prints a size in bytes for people, "-" if it is not known.
+func formatSize(size int64) string {
+	switch {
+	case size <= 0:
+		return "-"
+	case size < 1000*1000:
+		return fmt.Sprintf("%.1fKB", float64(size)/1000)
+	case size < 1000*1000*1000:
+		return fmt.Sprintf("%.1fMB", float64(size)/(1000*1000))
+	default:
+		return fmt.Sprintf("%.1fGB", float64(size)/(1000*1000*1000))
+	}
+}
+
+// formatDuration prints a duration as H:MM:SS, "-" if it is not known.
+func formatDuration(duration time.Duration) string {
+	if duration <= 0 {
+		return "-"
+	}
+	seconds := int(duration.Round(time.Second).Seconds())
+	return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
+
+// formatEpisodeNumber prints the season and episode numbers as S02E05, "-" if
+// neither is known.
+func formatEpisodeNumber(season int, number int) string {
+	switch {
+	case season > 0 && number > 0:
+		return fmt.Sprintf("S%02dE%02d", season, number)
+	case season > 0:
+		return fmt.Sprintf("S%02d", season)
+	case number > 0:
+		return fmt.Sprintf("E%02d", number)
+	}
+	return "-"
+}
+
+// addSelectorFlags adds the flags choosing episodes to cmd, stateFlag names the
+// flag selecting episodes by state.
+func addSelectorFlags(cmd *cobra.Command, stateFlag string) {
+	cmd.Flags().String("since", "", "select episodes published on or after this date, 2006-01-02 or RFC 3339")
+	cmd.Flags().String("until", "", "select episodes published on or before this date, 2006-01-02 or RFC 3339")
+	cmd.Flags().String("title", "", "select episodes whose title matches this regular expression")
+	cmd.Flags().StringSlice("guid", nil, "select episodes with these GUIDs or short IDs")
+	cmd.Flags().StringSlice(stateFlag, nil, "select episodes in one of these states")
+	cmd.Flags().Bool("all", false, "select every episode")
+}
+
+// episodeSelector builds the selector from the flags added by addSelectorFlags.
+func episodeSelector(cmd *cobra.Command, stateFlag string) feed.EpisodeSelector {
+	var selector feed.EpisodeSelector
+	since, err := cmd.Flags().GetString("since")
+	if err != nil {
+		log.Fatalf("could not parse --since flag: %v", err)
+	}
+	if since != "" {
+		selector.Since, err = feed.ParseSelectorDate(since, false)
+		if err != nil {
+			log.Fatalf("could not parse --since flag: %v", err)
+		}
+	}
+	until, err := cmd.Flags().GetString("until")
+	if err != nil {
+		log.Fatalf("could not parse --until flag: %v", err)
+	}
+	if until != "" {
+		selector.Until, err = feed.ParseSelectorDate(until, true)
+		if err != nil {
+			log.Fatalf("could not parse --until flag: %v", err)
+		}
+	}
+	title, err := cmd.Flags().GetString("title")
+	if err != nil {
+		log.Fatalf("could not parse --title flag: %v", err)
+	}
+	if title != "" {
+		selector.Title, err = regexp.Compile(title)
+		if err != nil {
+			log.Fatalf("could not parse --title flag: %v", err)
+		}
+	}
+	selector.IDs, err = cmd.Flags().GetStringSlice("guid")
+	if err != nil {
+		log.Fatalf("could not parse --guid flag: %v", err)
+	}
+	states, err := cmd.Flags().GetStringSlice(stateFlag)
+	if err != nil {
+		log.Fatalf("could not parse --%s flag: %v", stateFlag, err)
+	}
+	for _, name := range states {
+		state, err := feed.ParseEpisodeState(name)
+		if err != nil {
+			log.Fatalf("could not parse --%s flag: %v", stateFlag, err)
+		}
+		selector.States = append(selector.States, state)
+	}
+	selector.All, err = cmd.Flags().GetBool("all")
+	if err != nil {
+		log.Fatalf("could not parse --all flag: %v", err)
+	}
+	return selector
+}
+
+func init() {
+	rootCmd.AddCommand(episodesCmd)
+	addSelectorFlags(episodesCmd, "state")
+	episodesCmd.Flags().String("sort", "order", "sort by order, date, title, state, size or duration")
+	episodesCmd.Flags().Bool("reverse", false, "reverse the sort")
+	episodesCmd.Flags().StringP("output", "o", "table", "output format, table, json or csv")
+}
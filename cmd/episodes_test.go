@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"castigate/feed"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEpisodes(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(episodesCmd)
+	defer ResetFlags(markCmd)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	podcast := &feed.Podcast{
+		Label:     "test",
+		Feed:      "http://feed.example.com",
+		Directory: dir,
+		Start:     "oldest",
+		Episodes:  make(map[string]*feed.Episode, 0),
+	}
+	states := []feed.EpisodeState{feed.Downloaded, feed.Deleted, feed.New, feed.New}
+	for count, state := range states {
+		guid := fmt.Sprintf("https://example.com/episodes/%d", count)
+		podcast.Episodes[guid] = &feed.Episode{
+			GUID:     guid,
+			Title:    fmt.Sprintf("Episode %d", count),
+			State:    state,
+			Filename: fmt.Sprintf("episode-%d.mp3", count),
+			Length:   int64(1000 * (count + 1)),
+			Date:     time.Date(2020, 1, 1+count, 12, 0, 0, 0, time.UTC),
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "episode-0.mp3"), []byte(testAsset), 0644)
+	config.Podcasts = append(config.Podcasts, podcast)
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	episodes := func(args ...string) string {
+		return RunCommand(t, fn, append([]string{"episodes", "test"}, args...)...)
+	}
+
+	output := episodes()
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 5 || !strings.HasPrefix(lines[0], "ID") {
+		t.Fatalf("expected a header and 4 episodes, got\n%s", output)
+	}
+	first := podcast.Episodes["https://example.com/episodes/0"]
+	if !strings.HasPrefix(lines[1], first.ShortID()) || !strings.Contains(lines[1], "downloaded") ||
+		!strings.Contains(lines[1], "2020-01-01") || !strings.Contains(lines[1], "episode-0.mp3") {
+		t.Errorf("unexpected first episode %q", lines[1])
+	}
+
+	// filter by state, newest first, as JSON
+	var rows []episodeRow
+	err = json.Unmarshal([]byte(episodes("--state", "new", "--sort", "date", "--reverse", "-o", "json")), &rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].Title != "Episode 3" || rows[1].Title != "Episode 2" || rows[0].Size != 4000 {
+		t.Errorf("unexpected episodes %+v", rows)
+	}
+
+	// the size on disk replaces the feed's and dates are inclusive
+	records, err := csv.NewReader(strings.NewReader(episodes("--until", "2020-01-02", "--output", "csv"))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 3 || records[1][4] != fmt.Sprintf("%d", len(testAsset)) || records[2][3] != "deleted" {
+		t.Errorf("unexpected csv %v", records)
+	}
+
+	// short IDs are accepted in place of GUIDs
+	second := podcast.Episodes["https://example.com/episodes/1"]
+	ResetFlags(markCmd)
+	rootCmd.SetArgs([]string{"--config", fn, "episodes", "mark", "test", "--state", "skipped", "--guid", second.ShortID()})
+	err = rootCmd.Execute()
+	if err != nil {
+		t.Fatalf("error marking episodes: %v", err)
+	}
+	config, err = backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	episode, err := config.Podcasts[0].FindEpisode(second.ShortID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if episode.GUID != second.GUID || episode.State != feed.Skipped {
+		t.Errorf("expected %s to be skipped by its short ID, got %s %v", second.GUID, episode.GUID, episode.State)
+	}
+}
@@ -0,0 +1,93 @@
+/*
+Copyright © 2023 Daniel Blezek <blezek.daniel@mayo.edu>
+This file is part of a CLI application.
+*/
+package cmd
+
+import (
+	"castigate/feed"
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"syscall"
+)
+
+// fetchCmd represents the fetch command
+var fetchCmd = &cobra.Command{
+	Use:   "fetch <label> <episode-id|title-regex>",
+	Short: "download one episode",
+	Long: `Download a single episode of a podcast, whatever its state and outside the
+count to keep.  The episode is given by its GUID, its short ID as shown by
+"castigate episodes", or a regular expression matching exactly one title.  The
+feed is refreshed first.  The episode is pinned, so it does not count against
+the count to keep, and added to the playlist.`,
+	Args: cobra.ExactArgs(2),
+	Run:  runFetchCmd,
+}
+
+func runFetchCmd(cmd *cobra.Command, args []string) {
+	backend, config := LoadConfiguration(cmd)
+	configFilePath := filepath.Dir(backend.Filename)
+
+	label := args[0]
+	podcast, err := config.FindPodcast(label)
+	if err != nil {
+		log.Fatalf("could not find podcast with label %s: %v", label, err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	_, err = podcast.UpdateFromRSS(ctx, config, configFilePath)
+	if err != nil {
+		log.Errorf("could not update %s, using the saved episodes: %v", podcast.Label, err)
+	}
+
+	episode, err := findEpisode(podcast, args[1])
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	err = podcast.Fetch(ctx, config, configFilePath, episode)
+	// save the state even if the download failed, it records the failure
+	saveErr := backend.Save(config)
+	if saveErr != nil {
+		log.Fatalf("error saving config: %v", saveErr)
+	}
+	if err != nil {
+		log.Fatalf("could not fetch %s: %v", episode.Title, err)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "fetched and pinned %s: %s\n", episode.ShortID(), episode.Title)
+}
+
+// findEpisode finds the episode by GUID or short ID, or else by a title matching
+// the regular expression.  It is an error if more than one title matches.
+func findEpisode(podcast *feed.Podcast, id string) (*feed.Episode, error) {
+	episode, err := podcast.FindEpisode(id)
+	if err == nil {
+		return episode, nil
+	}
+	title, err := regexp.Compile(id)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not an episode ID of %s or a valid regular expression: %w", id, podcast.Label, err)
+	}
+	matches := podcast.SelectEpisodes(feed.EpisodeSelector{Title: title})
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no episode of %s has the ID or a title matching %s", podcast.Label, id)
+	case 1:
+		return matches[0], nil
+	}
+	message := fmt.Sprintf("%d episodes of %s match %s, use the ID of one:", len(matches), podcast.Label, id)
+	for _, match := range matches {
+		message += fmt.Sprintf("\n  %s  %s", match.ShortID(), match.Title)
+	}
+	return nil, fmt.Errorf("%s", message)
+}
+
+func init() {
+	rootCmd.AddCommand(fetchCmd)
+}
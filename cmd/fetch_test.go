@@ -0,0 +1,138 @@
+package cmd
+
+import (
+	"bytes"
+	"castigate/feed"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFetch(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(syncCmd)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ts := CreateTestServer(t)
+	defer ts.Close()
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheDirectory = ""
+	config.Podcasts = append(config.Podcasts, &feed.Podcast{
+		Label:       "fetch",
+		Feed:        ts.URL + "/rss",
+		Directory:   dir,
+		CountToKeep: 2,
+		Start:       "oldest",
+		Episodes:    make(map[string]*feed.Episode, 0),
+	})
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	RunSync(t, fn, &backend, "fetch")
+	// an old episode by title, outside the count to keep
+	output := RunCommand(t, fn, "fetch", "fetch", "episode #50$")
+	podcast, config := LoadPodcast(t, &backend, "fetch")
+	old := podcast.Episodes["episode-050"]
+	if old.State != feed.Pinned || !FileExists(filepath.Join(dir, old.Filename)) {
+		t.Errorf("expected episode-050 to be fetched and pinned, got %v", old.State)
+	}
+	if !strings.Contains(output, "fetched and pinned "+old.ShortID()) {
+		t.Errorf("unexpected output %q", output)
+	}
+	// a downloaded episode by short ID is pinned without downloading it again
+	RunCommand(t, fn, "fetch", "fetch", podcast.Episodes["episode-001"].ShortID())
+	podcast, _ = LoadPodcast(t, &backend, "fetch")
+	if podcast.Episodes["episode-001"].State != feed.Pinned {
+		t.Errorf("expected episode-001 to be pinned, got %v", podcast.Episodes["episode-001"].State)
+	}
+
+	// a failed fetch is recorded for the retries of later syncs
+	failing := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	episode := podcast.Episodes["episode-060"]
+	episode.URL = failing.URL + "/asset/episode-060.mp3"
+	err = podcast.Fetch(context.Background(), config, filepath.Dir(fn), episode)
+	if err == nil {
+		t.Errorf("expected fetching from a failing server to fail")
+	}
+	if episode.State != feed.New || episode.Attempts != 1 || episode.LastStatus != http.StatusServiceUnavailable {
+		t.Errorf("expected one failed attempt with status 503, got %v %d %d", episode.State, episode.Attempts, episode.LastStatus)
+	}
+	// a pinned episode whose file went missing stays pinned when it can not be downloaded again
+	config.MaxAttempts = 1
+	missing := &feed.Episode{GUID: "missing", Filename: "missing.mp3", URL: failing.URL + "/asset/missing.mp3", State: feed.Pinned}
+	podcast.Episodes["missing"] = missing
+	err = podcast.Fetch(context.Background(), config, filepath.Dir(fn), missing)
+	if err == nil || missing.State != feed.Pinned || missing.Attempts != 1 {
+		t.Errorf("expected the missing file to fail and stay pinned, got %v %v after %d attempts", err, missing.State, missing.Attempts)
+	}
+
+	// pinned episodes do not count against the count to keep
+	podcast, _ = RunSync(t, fn, &backend, "fetch")
+	if podcast.GetDownloadedCount() != 2 || podcast.Episodes["episode-002"].State != feed.Downloaded {
+		t.Errorf("expected episode-000 and episode-002 to be downloaded, got %d downloaded", podcast.GetDownloadedCount())
+	}
+	playlist, err := os.ReadFile(filepath.Join(dir, podcast.PlaylistFilename()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ""
+	for _, guid := range []string{"episode-000", "episode-001", "episode-002", "episode-050"} {
+		expected += podcast.Episodes[guid].Filename + "\n"
+	}
+	if string(playlist) != expected {
+		t.Errorf("unexpected playlist\nexpected:\n%s\nactual:\n%s", expected, playlist)
+	}
+}
+
+func TestFetchWithoutEpisodes(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ts := CreateTestServer(t)
+	defer ts.Close()
+
+	// a hand written podcast without an episodes key
+	contents, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	podcast := fmt.Sprintf("podcasts:\n    - label: fetch\n      feed: %s/rss\n      directory: %s\n      start: oldest\n", ts.URL, dir)
+	contents = bytes.Replace(contents, []byte("podcasts: []\n"), []byte(podcast), 1)
+	contents = bytes.Replace(contents, []byte("cachedirectory: cache\n"), []byte("cachedirectory: \"\"\n"), 1)
+	err = os.WriteFile(fn, contents, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	RunCommand(t, fn, "fetch", "fetch", "episode-003")
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	fetched, _ := LoadPodcast(t, &backend, "fetch")
+	episode := fetched.Episodes["episode-003"]
+	if episode == nil || episode.State != feed.Pinned || !FileExists(filepath.Join(dir, episode.Filename)) {
+		t.Errorf("expected episode-003 to be fetched and pinned")
+	}
+}
@@ -15,19 +15,29 @@ import (
 var listCmd = &cobra.Command{
 	Use:   "list",
 	Short: "list podcasts",
-	Long:  ``,
-	Args:  cobra.NoArgs,
-	Run:   RunListCmd,
+	Long: `List the podcasts and the state of their episodes.
+            --tag lists only the podcasts with one of the tags`,
+	Args: cobra.NoArgs,
+	Run:  RunListCmd,
 }
 
 func RunListCmd(cmd *cobra.Command, args []string) {
 	_, config := LoadConfiguration(cmd)
 	log.Debugf("Loaded configuration: %v", config)
-	for _, podcast := range config.Podcasts {
-		fmt.Print(podcast.PrintDetails())
+	tags, err := cmd.Flags().GetStringSlice("tag")
+	if err != nil {
+		log.Fatalf("could not parse --tag flag: %v", err)
+	}
+	podcasts, err := config.SelectPodcasts(nil, tags)
+	if err != nil {
+		log.Fatalf("could not select podcasts: %v", err)
+	}
+	for _, podcast := range podcasts {
+		fmt.Fprint(cmd.OutOrStdout(), podcast.PrintDetails())
 	}
 }
 
 func init() {
 	rootCmd.AddCommand(listCmd)
+	listCmd.Flags().StringSlice("tag", nil, "only list podcasts with one of these tags")
 }
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"castigate/feed"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -46,6 +47,47 @@ func TestList(t *testing.T) {
 	}
 }
 
+func TestListTags(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(listCmd)
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, label := range []string{"kids", "news"} {
+		config.Podcasts = append(config.Podcasts, &feed.Podcast{
+			Label:    label,
+			Feed:     "http://feed.example.com/" + label,
+			Start:    "oldest",
+			Tags:     []string{label},
+			Episodes: make(map[string]*feed.Episode, 0),
+		})
+	}
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := new(bytes.Buffer)
+	rootCmd.SetOut(buffer)
+	rootCmd.SetErr(buffer)
+	rootCmd.SetArgs([]string{"--config", fn, "list", "--tag", "kids"})
+	err = rootCmd.Execute()
+	if err != nil {
+		t.Fatalf("error listing podcasts: %v", err)
+	}
+	if !strings.Contains(buffer.String(), "Label: kids\n") || strings.Contains(buffer.String(), "Label: news") {
+		t.Fatalf("expected only the kids podcast to be listed, got\n%s", buffer.String())
+	}
+	if !strings.Contains(buffer.String(), "Tags: kids\n") {
+		t.Fatalf("expected the tags to be listed, got\n%s", buffer.String())
+	}
+}
+
 const expectedOutput = `Title: 
 Label: test
 Feed: http://feed.example.com
@@ -0,0 +1,139 @@
+/*
+Copyright © 2023 Daniel Blezek <blezek.daniel@mayo.edu>
+This file is part of a CLI application.
+*/
+package cmd
+
+import (
+	"castigate/feed"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"path/filepath"
+)
+
+// markCmd represents the episodes mark command
+var markCmd = &cobra.Command{
+	Use:   "mark <label>",
+	Short: "change the state of episodes",
+	Long: `Mark the selected episodes of a podcast as new, deleted, skipped or played.
+Marking episodes new downloads them again, for instance to re-download a season,
+marking them skipped hides an old backlog and marking them played removes their
+files, or moves them to the trash directory, and never downloads them again.
+Marking downloaded or pinned episodes deleted removes their files the same way.
+At least one selector, or --all, is required and an episode is marked if it
+matches every selector.
+  --state is new, deleted, skipped or played
+  --since and --until select episodes by date, 2006-01-02 or RFC 3339, inclusive
+  --title selects episodes whose title matches a regular expression
+  --guid selects episodes by GUID or by the short ID shown by "castigate episodes"
+  --from-state selects episodes in one of the states
+  --all selects every episode
+  --dry-run prints the summary without changing anything`,
+	Args: cobra.ExactArgs(1),
+	Run:  runMarkCmd,
+}
+
+// markableStates are the states episodes may be marked with
+var markableStates = []feed.EpisodeState{feed.New, feed.Deleted, feed.Skipped, feed.Played}
+
+func runMarkCmd(cmd *cobra.Command, args []string) {
+	backend, config := LoadConfiguration(cmd)
+
+	label := args[0]
+	podcast, err := config.FindPodcast(label)
+	if err != nil {
+		log.Fatalf("could not find podcast with label %s: %v", label, err)
+	}
+	stateName, err := cmd.Flags().GetString("state")
+	if err != nil {
+		log.Fatalf("could not parse --state flag: %v", err)
+	}
+	state, err := feed.ParseEpisodeState(stateName)
+	if err != nil || !markable(state) {
+		log.Fatalf("could not parse --state flag: expected new, deleted, skipped or played, got %q", stateName)
+	}
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		log.Fatalf("could not parse --dry-run flag: %v", err)
+	}
+	selector := episodeSelector(cmd, "from-state")
+	if selector.IsEmpty() {
+		log.Fatalf("no episodes selected, use --all to mark every episode")
+	}
+
+	selected := podcast.SelectEpisodes(selector)
+	changed := make(map[feed.EpisodeState]int)
+	unchanged := 0
+	refused := make(map[feed.EpisodeState]int)
+	for _, episode := range selected {
+		from := episode.State
+		switch {
+		case from == state:
+			unchanged++
+		case !feed.CanTransition(from, state):
+			refused[from]++
+		case dryRun:
+			changed[from]++
+		default:
+			err = podcast.Mark(config, filepath.Dir(backend.Filename), episode, state)
+			if err != nil {
+				log.Fatalf("could not mark %s: %v", episode.Title, err)
+			}
+			changed[from]++
+		}
+	}
+
+	out := cmd.OutOrStdout()
+	verb := "marked"
+	if dryRun {
+		verb = "would mark"
+	}
+	fmt.Fprintf(out, "%s: %d episodes selected\n", podcast.Label, len(selected))
+	for _, from := range stateOrder() {
+		if changed[from] > 0 {
+			fmt.Fprintf(out, "  %s %d %s episodes %s\n", verb, changed[from], from, state)
+		}
+	}
+	if unchanged > 0 {
+		fmt.Fprintf(out, "  %d episodes were already %s\n", unchanged, state)
+	}
+	for _, from := range stateOrder() {
+		if refused[from] > 0 {
+			fmt.Fprintf(out, "  %d %s episodes can not be marked %s\n", refused[from], from, state)
+		}
+	}
+
+	if dryRun {
+		return
+	}
+	err = backend.Save(config)
+	if err != nil {
+		log.Fatalf("error saving config: %v", err)
+	}
+}
+
+func markable(state feed.EpisodeState) bool {
+	for _, s := range markableStates {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// stateOrder lists every episode state, for stable output.
+func stateOrder() []feed.EpisodeState {
+	states := make([]feed.EpisodeState, 0)
+	for state := feed.New; state <= feed.Played; state++ {
+		states = append(states, state)
+	}
+	return states
+}
+
+func init() {
+	episodesCmd.AddCommand(markCmd)
+	markCmd.Flags().String("state", "", "the new state of the episodes, new, deleted, skipped or played")
+	markCmd.Flags().Bool("dry-run", false, "print what would be marked without changing anything")
+	addSelectorFlags(markCmd, "from-state")
+}
@@ -0,0 +1,157 @@
+package cmd
+
+import (
+	"castigate/feed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMark(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(markCmd)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	podcast := &feed.Podcast{
+		Label:     "test",
+		Feed:      "http://feed.example.com",
+		Directory: dir,
+		Start:     "oldest",
+		Episodes:  make(map[string]*feed.Episode, 0),
+	}
+	// episode-000 to 009, one a day from 2020-01-01, the first three deleted
+	for count := 0; count < 10; count++ {
+		guid := fmt.Sprintf("episode-%03d", count)
+		state := feed.New
+		if count < 3 {
+			state = feed.Deleted
+		}
+		podcast.Episodes[guid] = &feed.Episode{
+			GUID:  guid,
+			Title: fmt.Sprintf("Season %d episode %d", count/5+1, count),
+			State: state,
+			Date:  time.Date(2020, 1, 1+count, 12, 0, 0, 0, time.Local),
+		}
+	}
+	config.Podcasts = append(config.Podcasts, podcast)
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mark := func(args ...string) (map[string]*feed.Episode, string) {
+		output := RunCommand(t, fn, append([]string{"episodes", "mark", "test"}, args...)...)
+		podcast, _ := LoadPodcast(t, &backend, "test")
+		return podcast.Episodes, output
+	}
+	expectStates := func(episodes map[string]*feed.Episode, expected string) {
+		t.Helper()
+		actual := ""
+		for count := 0; count < 10; count++ {
+			actual += episodes[fmt.Sprintf("episode-%03d", count)].State.String()[:1]
+		}
+		if actual != expected {
+			t.Errorf("expected states %s, got %s", expected, actual)
+		}
+	}
+
+	// a dry run changes nothing
+	episodes, output := mark("--state", "skipped", "--all", "--dry-run")
+	expectStates(episodes, "dddnnnnnnn")
+	if !strings.Contains(output, "would mark 7 new episodes skipped") || !strings.Contains(output, "would mark 3 deleted episodes skipped") {
+		t.Errorf("unexpected summary\n%s", output)
+	}
+
+	// hide the backlog up to and including the 4th
+	episodes, output = mark("--state", "skipped", "--until", "2020-01-04", "--from-state", "new")
+	expectStates(episodes, "dddsnnnnnn")
+	if !strings.Contains(output, "test: 1 episodes selected") || !strings.Contains(output, "marked 1 new episodes skipped") {
+		t.Errorf("unexpected summary\n%s", output)
+	}
+	history := episodes["episode-003"].History
+	if len(history) != 1 || history[0].From != feed.New || history[0].Reason != "marked skipped" {
+		t.Errorf("unexpected history %+v", history)
+	}
+
+	// re-download season 1
+	episodes, output = mark("--state", "new", "--title", "^Season 1 ", "--since", "2020-01-02")
+	expectStates(episodes, "dnnnnnnnnn")
+	if !strings.Contains(output, "marked 2 deleted episodes new") || !strings.Contains(output, "marked 1 skipped episodes new") ||
+		!strings.Contains(output, "1 episodes were already new") {
+		t.Errorf("unexpected summary\n%s", output)
+	}
+
+	// downloaded episodes can not go back to new
+	config, err = backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Podcasts[0].Episodes["episode-009"].State = feed.Downloaded
+	config.Podcasts[0].Episodes["episode-009"].Filename = "episode-009.mp3"
+	err = os.WriteFile(filepath.Join(dir, "episode-009.mp3"), []byte(testAsset), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	episodes, output = mark("--state", "new", "--guid", "episode-009,episode-000")
+	expectStates(episodes, "nnnnnnnnnd")
+	if !strings.Contains(output, "1 downloaded episodes can not be marked new") {
+		t.Errorf("unexpected summary\n%s", output)
+	}
+
+	// a played episode's file is removed and it is not downloaded again
+	episodes, output = mark("--state", "played", "--guid", "episode-009,episode-008")
+	expectStates(episodes, "nnnnnnnnpp")
+	if !strings.Contains(output, "marked 1 downloaded episodes played") || !strings.Contains(output, "marked 1 new episodes played") {
+		t.Errorf("unexpected summary\n%s", output)
+	}
+	if FileExists(filepath.Join(dir, "episode-009.mp3")) {
+		t.Errorf("expected the played episode's file to be removed")
+	}
+	config, err = backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, episode := range config.Podcasts[0].Plan(config, filepath.Dir(fn)).Candidates {
+		if episode.State == feed.Played {
+			t.Errorf("expected played episodes not to be downloaded, got %s", episode.GUID)
+		}
+	}
+
+	// a deleted episode's file is moved to the trash directory
+	config.TrashDirectory = filepath.Join(dir, "trash")
+	config.Podcasts[0].Episodes["episode-007"].State = feed.Pinned
+	config.Podcasts[0].Episodes["episode-007"].Filename = "episode-007.mp3"
+	err = os.WriteFile(filepath.Join(dir, "episode-007.mp3"), []byte(testAsset), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	episodes, output = mark("--state", "deleted", "--guid", "episode-007")
+	expectStates(episodes, "nnnnnnndpp")
+	if !strings.Contains(output, "marked 1 pinned episodes deleted") {
+		t.Errorf("unexpected summary\n%s", output)
+	}
+	if FileExists(filepath.Join(dir, "episode-007.mp3")) || !FileExists(filepath.Join(dir, "trash", "test", "episode-007.mp3")) {
+		t.Errorf("expected the deleted episode's file to be moved to the trash directory")
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright © 2023 Daniel Blezek <blezek.daniel@mayo.edu>
+This file is part of a CLI application.
+*/
+package cmd
+
+import (
+	"castigate/feed"
+	"context"
+	"encoding/json"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"io"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+// planCmd represents the plan command
+var planCmd = &cobra.Command{
+	Use:   "plan [label...]",
+	Short: "show what sync would do",
+	Long: `Fetch the feeds and show which episodes sync would download, which would
+move from downloaded to deleted, and the resulting playlists.  Nothing is downloaded
+and neither the state nor the files on disk are changed.  Only the podcasts with the
+given labels are planned, or every podcast if none are given.
+  --tag plans the podcasts with one of the tags
+  --offline uses the cached feeds
+  --output is "table" or "json"`,
+	Args: cobra.ArbitraryArgs,
+	Run:  runPlanCmd,
+}
+
+type planEpisode struct {
+	GUID     string    `json:"guid"`
+	Title    string    `json:"title"`
+	Date     time.Time `json:"date"`
+	Filename string    `json:"filename"`
+}
+
+type podcastPlan struct {
+	Label            string        `json:"label"`
+	Title            string        `json:"title"`
+	Download         []planEpisode `json:"download"`
+	Delete           []planEpisode `json:"delete"`
+	Prune            []planEpisode `json:"prune"`
+	Aged             []planEpisode `json:"aged"`
+	Replace          []planEpisode `json:"replace"`
+	Deferred         bool          `json:"deferred"`
+	PlaylistFilename string        `json:"playlist_filename"`
+	Playlist         []string      `json:"playlist"`
+}
+
+func runPlanCmd(cmd *cobra.Command, args []string) {
+	backend, config := LoadConfiguration(cmd)
+	tags, err := cmd.Flags().GetStringSlice("tag")
+	if err != nil {
+		log.Fatalf("could not parse --tag flag: %v", err)
+	}
+	config.Offline, err = cmd.Flags().GetBool("offline")
+	if err != nil {
+		log.Fatalf("could not parse --offline flag: %v", err)
+	}
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		log.Fatalf("could not parse --output flag: %v", err)
+	}
+	selected, err := config.SelectPodcasts(args, tags)
+	if err != nil {
+		log.Fatalf("could not select podcasts: %v", err)
+	}
+	PrintPlan(cmd.OutOrStdout(), config, filepath.Dir(backend.Filename), selected, output)
+}
+
+// PrintPlan updates copies of the podcasts from their feeds and prints what a
+// sync would do, as a table or as JSON.
+func PrintPlan(out io.Writer, config feed.Config, configFilePath string, podcasts []*feed.Podcast, output string) {
+	if output != "table" && output != "json" {
+		log.Fatalf("unknown output format %s, expected table or json", output)
+	}
+	config.DryRun = true
+	plans := make([]podcastPlan, 0, len(podcasts))
+	for _, podcast := range podcasts {
+		clone := podcast.Clone()
+		_, err := clone.UpdateFromRSS(context.Background(), config, configFilePath)
+		if err != nil {
+			log.Errorf("could not update %s, planning from the saved state: %v", podcast.Label, err)
+		}
+		plan := clone.Plan(config, configFilePath)
+		p := podcastPlan{
+			Label:            clone.Label,
+			Title:            clone.Title,
+			Download:         planEpisodes(plan.Downloads()),
+			Delete:           planEpisodes(plan.Deleted),
+			Prune:            planEpisodes(plan.Evictions(config)),
+			Aged:             planEpisodes(plan.Aged),
+			Replace:          planEpisodes(plan.Replaced),
+			Deferred:         plan.Deferred,
+			PlaylistFilename: clone.PlaylistFilename(),
+			Playlist:         make([]string, 0),
+		}
+		for _, episode := range plan.Playlist(config) {
+			p.Playlist = append(p.Playlist, episode.Filename)
+		}
+		plans = append(plans, p)
+	}
+
+	if output == "json" {
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		err := encoder.Encode(plans)
+		if err != nil {
+			log.Fatalf("could not write plan: %v", err)
+		}
+		return
+	}
+	for _, p := range plans {
+		fmt.Fprintf(out, "%s (%s): %d to download, %d to mark deleted\n", p.Label, p.Title, len(p.Download), len(p.Delete))
+		if p.Deferred {
+			fmt.Fprintf(out, "  downloads deferred until the next download window\n")
+		}
+		if len(p.Download)+len(p.Delete)+len(p.Prune)+len(p.Aged)+len(p.Replace) > 0 {
+			writer := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+			fmt.Fprintf(writer, "  ACTION\tDATE\tTITLE\tFILENAME\n")
+			for _, episode := range p.Download {
+				fmt.Fprintf(writer, "  download\t%s\t%s\t%s\n", episode.Date.Format(time.DateOnly), episode.Title, episode.Filename)
+			}
+			for _, episode := range p.Delete {
+				fmt.Fprintf(writer, "  deleted\t%s\t%s\t%s\n", episode.Date.Format(time.DateOnly), episode.Title, episode.Filename)
+			}
+			for _, episode := range p.Replace {
+				fmt.Fprintf(writer, "  replace\t%s\t%s\t%s\n", episode.Date.Format(time.DateOnly), episode.Title, episode.Filename)
+			}
+			for _, episode := range p.Aged {
+				fmt.Fprintf(writer, "  aged\t%s\t%s\t%s\n", episode.Date.Format(time.DateOnly), episode.Title, episode.Filename)
+			}
+			for _, episode := range p.Prune {
+				fmt.Fprintf(writer, "  prune\t%s\t%s\t%s\n", episode.Date.Format(time.DateOnly), episode.Title, episode.Filename)
+			}
+			writer.Flush()
+		}
+		fmt.Fprintf(out, "  playlist %s:\n", p.PlaylistFilename)
+		for _, filename := range p.Playlist {
+			fmt.Fprintf(out, "    %s\n", filename)
+		}
+		fmt.Fprintf(out, "\n")
+	}
+}
+
+func planEpisodes(episodes []*feed.Episode) []planEpisode {
+	p := make([]planEpisode, 0, len(episodes))
+	for _, episode := range episodes {
+		p = append(p, planEpisode{
+			GUID:     episode.GUID,
+			Title:    episode.Title,
+			Date:     episode.Date,
+			Filename: episode.Filename,
+		})
+	}
+	return p
+}
+
+func init() {
+	rootCmd.AddCommand(planCmd)
+	planCmd.Flags().StringSlice("tag", nil, "only plan podcasts with one of these tags")
+	planCmd.Flags().Bool("offline", false, "use the cached feeds")
+	planCmd.Flags().StringP("output", "o", "table", "output format, table or json")
+}
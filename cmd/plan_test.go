@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"bytes"
+	"castigate/feed"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(planCmd)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ts := CreateTestServer(t)
+	defer ts.Close()
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheDirectory = ""
+	config.Podcasts = append(config.Podcasts, &feed.Podcast{
+		Label:       "test",
+		Feed:        ts.URL + "/rss",
+		Directory:   filepath.Join(dir, "test"),
+		CountToKeep: 3,
+		Start:       "oldest",
+		Episodes:    make(map[string]*feed.Episode, 0),
+	})
+	podcast := config.Podcasts[0]
+	err = podcast.Sync(context.Background(), config, "")
+	if err != nil {
+		t.Fatalf("could not sync podcast: %v", err)
+	}
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first episode has been played
+	os.Remove(filepath.Join(podcast.Directory, podcast.Episodes["episode-000"].Filename))
+	saved, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buffer := new(bytes.Buffer)
+	rootCmd.SetOut(buffer)
+	rootCmd.SetArgs([]string{"--config", fn, "plan", "--output", "json"})
+	err = rootCmd.Execute()
+	if err != nil {
+		t.Fatalf("error planning podcasts: %v", err)
+	}
+	var plans []podcastPlan
+	err = json.Unmarshal(buffer.Bytes(), &plans)
+	if err != nil {
+		t.Fatalf("could not parse plan %s: %v", buffer.String(), err)
+	}
+	if len(plans) != 1 {
+		t.Fatalf("expected 1 plan, got %d", len(plans))
+	}
+	plan := plans[0]
+	if len(plan.Download) != 1 || plan.Download[0].GUID != "episode-003" {
+		t.Errorf("expected to download episode-003, got %v", plan.Download)
+	}
+	if len(plan.Delete) != 1 || plan.Delete[0].GUID != "episode-000" {
+		t.Errorf("expected to delete episode-000, got %v", plan.Delete)
+	}
+	expected := []string{
+		podcast.Episodes["episode-001"].Filename,
+		podcast.Episodes["episode-002"].Filename,
+		podcast.Episodes["episode-003"].Filename,
+	}
+	if strings.Join(plan.Playlist, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected playlist %v", plan.Playlist)
+	}
+
+	// nothing changed
+	after, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, after) {
+		t.Errorf("plan changed the config file")
+	}
+	if FileExists(filepath.Join(podcast.Directory, podcast.Episodes["episode-003"].Filename)) {
+		t.Errorf("plan downloaded an episode")
+	}
+
+	ResetFlags(planCmd)
+	buffer.Reset()
+	rootCmd.SetArgs([]string{"--config", fn, "plan"})
+	err = rootCmd.Execute()
+	if err != nil {
+		t.Fatalf("error planning podcasts: %v", err)
+	}
+	if !strings.Contains(buffer.String(), "test (test): 1 to download, 1 to mark deleted") {
+		t.Errorf("unexpected table output:\n%s", buffer.String())
+	}
+}
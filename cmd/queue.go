@@ -0,0 +1,145 @@
+/*
+Copyright © 2023 Daniel Blezek <blezek.daniel@mayo.edu>
+This file is part of a CLI application.
+*/
+package cmd
+
+import (
+	"castigate/feed"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"text/tabwriter"
+	"time"
+)
+
+// queueCmd represents the queue command
+var queueCmd = &cobra.Command{
+	Use:   "queue",
+	Short: "manage the download queue of a podcast",
+	Long: `Podcasts in manual mode, "castigate edit <label> --mode manual", only download
+the episodes in their queue, in queue order, and the playlist follows the queue.
+Episodes leave the queue once they have been downloaded and their file deleted.
+Episodes are given by GUID, short ID or a regular expression matching one title.
+  castigate queue add <label> <episode>...
+  castigate queue remove <label> <episode>...
+  castigate queue list <label>`,
+}
+
+// queueAddCmd represents the queue add command
+var queueAddCmd = &cobra.Command{
+	Use:   "add <label> <episode>...",
+	Short: "add episodes to the end of the queue",
+	Long: `Add episodes to the end of the queue, or with --front to the front.  Queued
+episodes that are not on disk are marked new so the next sync downloads them.`,
+	Args: cobra.MinimumNArgs(2),
+	Run:  runQueueAddCmd,
+}
+
+// queueRemoveCmd represents the queue remove command
+var queueRemoveCmd = &cobra.Command{
+	Use:   "remove <label> <episode>...",
+	Short: "remove episodes from the queue",
+	Args:  cobra.MinimumNArgs(2),
+	Run:   runQueueRemoveCmd,
+}
+
+// queueListCmd represents the queue list command
+var queueListCmd = &cobra.Command{
+	Use:   "list <label>",
+	Short: "list the queue",
+	Args:  cobra.ExactArgs(1),
+	Run:   runQueueListCmd,
+}
+
+func runQueueAddCmd(cmd *cobra.Command, args []string) {
+	backend, config := LoadConfiguration(cmd)
+	podcast, err := config.FindPodcast(args[0])
+	if err != nil {
+		log.Fatalf("could not find podcast with label %s: %v", args[0], err)
+	}
+	front, err := cmd.Flags().GetBool("front")
+	if err != nil {
+		log.Fatalf("could not parse --front flag: %v", err)
+	}
+	if !podcast.IsManual() {
+		log.Warnf("%s is not in manual mode, the queue is only used by manual podcasts", podcast.Label)
+	}
+	episodes := make([]*feed.Episode, 0, len(args)-1)
+	for _, id := range args[1:] {
+		episode, err := findEpisode(podcast, id)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		episodes = append(episodes, episode)
+	}
+	if front {
+		// keep the order of the arguments at the front of the queue
+		for index := len(episodes) - 1; index >= 0; index-- {
+			enqueue(podcast, episodes[index], true)
+		}
+	} else {
+		for _, episode := range episodes {
+			enqueue(podcast, episode, false)
+		}
+	}
+	for _, episode := range episodes {
+		fmt.Fprintf(cmd.OutOrStdout(), "queued %s: %s\n", episode.ShortID(), episode.Title)
+	}
+	err = backend.Save(config)
+	if err != nil {
+		log.Fatalf("error saving config: %v", err)
+	}
+}
+
+func enqueue(podcast *feed.Podcast, episode *feed.Episode, front bool) {
+	err := podcast.Enqueue(episode, front)
+	if err != nil {
+		log.Fatalf("could not queue %s: %v", episode.Title, err)
+	}
+}
+
+func runQueueRemoveCmd(cmd *cobra.Command, args []string) {
+	backend, config := LoadConfiguration(cmd)
+	podcast, err := config.FindPodcast(args[0])
+	if err != nil {
+		log.Fatalf("could not find podcast with label %s: %v", args[0], err)
+	}
+	for _, id := range args[1:] {
+		episode, err := findEpisode(podcast, id)
+		if err != nil {
+			log.Fatalf("%v", err)
+		}
+		if podcast.Dequeue(episode) {
+			fmt.Fprintf(cmd.OutOrStdout(), "removed %s: %s\n", episode.ShortID(), episode.Title)
+		} else {
+			log.Warnf("%s is not queued", episode.Title)
+		}
+	}
+	err = backend.Save(config)
+	if err != nil {
+		log.Fatalf("error saving config: %v", err)
+	}
+}
+
+func runQueueListCmd(cmd *cobra.Command, args []string) {
+	_, config := LoadConfiguration(cmd)
+	podcast, err := config.FindPodcast(args[0])
+	if err != nil {
+		log.Fatalf("could not find podcast with label %s: %v", args[0], err)
+	}
+	writer := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+	fmt.Fprintf(writer, "#\tID\tDATE\tSTATE\tTITLE\n")
+	for index, episode := range podcast.QueuedEpisodes() {
+		fmt.Fprintf(writer, "%d\t%s\t%s\t%s\t%s\n", index+1, episode.ShortID(), episode.Date.Format(time.DateOnly), episode.State, episode.Title)
+	}
+	writer.Flush()
+}
+
+func init() {
+	rootCmd.AddCommand(queueCmd)
+	queueCmd.AddCommand(queueAddCmd)
+	queueCmd.AddCommand(queueRemoveCmd)
+	queueCmd.AddCommand(queueListCmd)
+	queueAddCmd.Flags().Bool("front", false, "add the episodes to the front of the queue")
+}
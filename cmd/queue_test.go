@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"castigate/feed"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQueue(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(syncCmd)
+	defer ResetFlags(queueAddCmd)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ts := CreateTestServer(t)
+	defer ts.Close()
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheDirectory = ""
+	config.Podcasts = append(config.Podcasts, &feed.Podcast{
+		Label:       "manual",
+		Feed:        ts.URL + "/rss",
+		Directory:   dir,
+		CountToKeep: 1,
+		Start:       "oldest",
+		Episodes:    make(map[string]*feed.Episode, 0),
+	})
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectPlaylist := func(podcast *feed.Podcast, guids ...string) {
+		t.Helper()
+		playlist, err := os.ReadFile(filepath.Join(dir, podcast.PlaylistFilename()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := ""
+		for _, guid := range guids {
+			expected += podcast.Episodes[guid].Filename + "\n"
+		}
+		if string(playlist) != expected {
+			t.Errorf("unexpected playlist\nexpected:\n%s\nactual:\n%s", expected, playlist)
+		}
+	}
+
+	// a manual podcast refreshes the feed without downloading
+	RunCommand(t, fn, "edit", "manual", "--mode", "manual")
+	podcast, _ := RunSync(t, fn, &backend, "manual")
+	if len(podcast.Episodes) != 100 || podcast.GetDownloadedCount() != 0 {
+		t.Errorf("expected 100 episodes and no downloads, got %d and %d", len(podcast.Episodes), podcast.GetDownloadedCount())
+	}
+
+	RunCommand(t, fn, "queue", "add", "manual", "episode #10$", "episode #5$")
+	RunCommand(t, fn, "queue", "add", "manual", "--front", podcast.Episodes["episode-007"].ShortID())
+	podcast, _ = LoadPodcast(t, &backend, "manual")
+	if strings.Join(podcast.Queue, ",") != "episode-007,episode-010,episode-005" {
+		t.Errorf("unexpected queue %v", podcast.Queue)
+	}
+	output := RunCommand(t, fn, "queue", "list", "manual")
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[1], "1") || !strings.Contains(lines[1], "episode-007") {
+		t.Errorf("unexpected queue list\n%s", output)
+	}
+
+	// every queued episode is downloaded, whatever the count to keep, in queue order
+	podcast, _ = RunSync(t, fn, &backend, "manual")
+	if podcast.GetDownloadedCount() != 3 {
+		t.Errorf("expected the 3 queued episodes to be downloaded, got %d", podcast.GetDownloadedCount())
+	}
+	expectPlaylist(podcast, "episode-007", "episode-010", "episode-005")
+
+	// episodes removed from the queue stay on disk after the queue
+	RunCommand(t, fn, "queue", "remove", "manual", "episode-010")
+	podcast, _ = RunSync(t, fn, &backend, "manual")
+	expectPlaylist(podcast, "episode-007", "episode-005", "episode-010")
+
+	// listened episodes leave the queue
+	os.Remove(filepath.Join(dir, podcast.Episodes["episode-007"].Filename))
+	podcast, _ = RunSync(t, fn, &backend, "manual")
+	if strings.Join(podcast.Queue, ",") != "episode-005" || podcast.Episodes["episode-007"].State != feed.Deleted {
+		t.Errorf("expected episode-007 to be deleted and dequeued, got %v and %v", podcast.Queue, podcast.Episodes["episode-007"].State)
+	}
+	expectPlaylist(podcast, "episode-005", "episode-010")
+
+	// a deleted episode queued again is downloaded again
+	RunCommand(t, fn, "queue", "add", "manual", "episode-007")
+	podcast, _ = LoadPodcast(t, &backend, "manual")
+	if podcast.Episodes["episode-007"].State != feed.New {
+		t.Errorf("expected the queued episode to be new, got %v", podcast.Episodes["episode-007"].State)
+	}
+}
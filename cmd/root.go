@@ -69,12 +69,12 @@ func init() {
 	rootCmd.PersistentFlags().BoolP("debug", "d", false, "set logging to debug")
 }
 
-func LoadConfiguration(cmd *cobra.Command) (feed.FileBackend, feed.Config) {
+func LoadConfiguration(cmd *cobra.Command) (*feed.FileBackend, feed.Config) {
 	configFile, err := cmd.Flags().GetString("config")
 	if err != nil {
 		log.Fatalf("could not get config file: %v", err)
 	}
-	var backend feed.FileBackend
+	backend := &feed.FileBackend{}
 	backend.Init(configFile)
 	config, err := backend.Load()
 	if err != nil {
@@ -3,6 +3,8 @@ package cmd
 import (
 	"bytes"
 	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 	"testing"
 )
 
@@ -19,3 +21,16 @@ func TestRootCommand(t *testing.T) {
 		t.Errorf("Root command must set debug log level but was %v", log.GetLevel())
 	}
 }
+
+// ResetFlags restores the flags of cmd to their defaults, cobra keeps flag values
+// between calls to Execute in the same test binary.
+func ResetFlags(cmd *cobra.Command) {
+	cmd.Flags().VisitAll(func(flag *pflag.Flag) {
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			slice.Replace(nil)
+		} else {
+			flag.Value.Set(flag.DefValue)
+		}
+		flag.Changed = false
+	})
+}
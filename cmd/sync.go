@@ -5,32 +5,180 @@ This file is part of a CLI application.
 package cmd
 
 import (
+	"castigate/feed"
+	"context"
+	"fmt"
 	"github.com/davecgh/go-spew/spew"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"os/signal"
 	"path/filepath"
+	"sync"
+	"syscall"
 )
 
 // syncCmd represents the sync command
 var syncCmd = &cobra.Command{
-	Use:   "sync",
+	Use:   "sync [label...]",
 	Short: "Download and sync podcasts",
 	Long: `Load the config file, fetch episodes from the RSS feed,
            compare to the files downloaded or deleted.  Updates files
-           to keep the count of local files.`,
-	Args: cobra.ExactArgs(0),
+           to keep the count of local files.  Only the podcasts with the
+           given labels are synced, or every podcast if none are given.
+           Feeds that moved, by a permanent redirect or itunes:new-feed-url,
+           are followed and the moves are reported when the sync is done.
+             --tag syncs the podcasts with one of the tags
+             --jobs is the number of feeds fetched and episodes downloaded at once
+             --jobs-per-host limits concurrent downloads from a single host, 0 for no limit
+             --offline rebuilds state and playlists from the cached feeds without downloading
+             --checkpoint-episodes records each episode as it is downloaded, not only each podcast
+             --rate-limit limits the total download bandwidth, for instance 500KB per second
+             --dry-run shows what would be done without changing anything, like 'castigate plan'`,
+	Args: cobra.ArbitraryArgs,
 	Run:  Sync,
 }
 
 func Sync(cmd *cobra.Command, args []string) {
 	backend, config := LoadConfiguration(cmd)
-	for _, podcast := range config.Podcasts {
-		podcast.Sync(config, filepath.Dir(backend.Filename))
-		log.Debugf("found podcast: %#v", spew.Sdump(podcast))
+	tags, err := cmd.Flags().GetStringSlice("tag")
+	if err != nil {
+		log.Fatalf("could not parse --tag flag: %v", err)
+	}
+	selected, err := config.SelectPodcasts(args, tags)
+	if err != nil {
+		log.Fatalf("could not select podcasts: %v", err)
+	}
+	config.Offline, err = cmd.Flags().GetBool("offline")
+	if err != nil {
+		log.Fatalf("could not parse --offline flag: %v", err)
+	}
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		log.Fatalf("could not parse --dry-run flag: %v", err)
+	}
+	if dryRun {
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			log.Fatalf("could not parse --output flag: %v", err)
+		}
+		PrintPlan(cmd.OutOrStdout(), config, filepath.Dir(backend.Filename), selected, output)
+		return
+	}
+
+	// cancel on the first signal, a second signal terminates immediately
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	jobs, err := cmd.Flags().GetInt("jobs")
+	if err != nil {
+		log.Fatalf("could not parse --jobs flag: %v", err)
+	}
+	jobsPerHost, err := cmd.Flags().GetInt("jobs-per-host")
+	if err != nil {
+		log.Fatalf("could not parse --jobs-per-host flag: %v", err)
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+	config.Limiter = feed.NewLimiter(jobs, jobsPerHost)
+	rateLimit, err := cmd.Flags().GetString("rate-limit")
+	if err != nil {
+		log.Fatalf("could not parse --rate-limit flag: %v", err)
+	}
+	if rateLimit != "" {
+		config.RateLimit, err = feed.ParseByteSize(rateLimit)
+		if err != nil {
+			log.Fatalf("could not parse --rate-limit flag: %v", err)
+		}
+	}
+	config.RateLimiter = feed.NewRateLimiter(config.RateLimit)
+	config.DiskBudget = feed.NewDiskBudget(config, filepath.Dir(backend.Filename))
+	checkpointEpisodes, err := cmd.Flags().GetBool("checkpoint-episodes")
+	if err != nil {
+		log.Fatalf("could not parse --checkpoint-episodes flag: %v", err)
+	}
+	if checkpointEpisodes {
+		config.EpisodeCheckpoint = func(podcast *feed.Podcast, episode *feed.Episode) {
+			err := backend.CheckpointEpisode(podcast, podcast.EpisodeKey(episode), episode)
+			if err != nil {
+				log.Errorf("could not checkpoint episode %s: %v", episode.Filename, err)
+			}
+		}
+	}
+
+	// remember the feed moves so far for the summary
+	moves := make(map[*feed.Podcast]int, len(selected))
+	for _, podcast := range selected {
+		moves[podcast] = len(podcast.FeedHistory)
+	}
+
+	// fetch feeds using a pool of workers, downloads share the config's limiter
+	podcasts := make(chan *feed.Podcast)
+	var wg sync.WaitGroup
+	for worker := 0; worker < jobs; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for podcast := range podcasts {
+				podcast.Sync(ctx, config, filepath.Dir(backend.Filename))
+				err := backend.CheckpointPodcast(podcast)
+				if err != nil {
+					log.Errorf("could not checkpoint podcast %s: %v", podcast.Label, err)
+				}
+				log.Debugf("found podcast: %#v", spew.Sdump(podcast))
+			}
+		}()
+	}
+dispatch:
+	for _, podcast := range selected {
+		select {
+		case podcasts <- podcast:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(podcasts)
+	wg.Wait()
+	printSyncSummary(cmd.OutOrStdout(), selected, moves)
+	err = backend.Save(config)
+	if err != nil {
+		log.Fatalf("error saving config: %v", err)
+	}
+	if ctx.Err() != nil {
+		log.Warnf("sync interrupted, completed work has been saved")
+		os.Exit(1)
+	}
+}
+
+// printSyncSummary reports the feeds that moved during the sync, moves holds the
+// length of each podcast's FeedHistory before the sync, and the moves waiting for
+// confirmation.
+func printSyncSummary(out io.Writer, podcasts []*feed.Podcast, moves map[*feed.Podcast]int) {
+	for _, podcast := range podcasts {
+		for _, move := range podcast.FeedHistory[moves[podcast]:] {
+			fmt.Fprintf(out, "%s: the feed moved from %s to %s (%s)\n", podcast.Label, move.From, move.To, move.Reason)
+		}
+		if podcast.PendingFeed != "" {
+			fmt.Fprintf(out, "%s: the feed moved to %s, follow it with castigate edit %s --accept-feed-move\n",
+				podcast.Label, podcast.PendingFeed, podcast.Label)
+		}
 	}
-	backend.Save(config)
 }
 
 func init() {
 	rootCmd.AddCommand(syncCmd)
+	syncCmd.Flags().IntP("jobs", "j", 4, "number of feeds to fetch and episodes to download in parallel")
+	syncCmd.Flags().Int("jobs-per-host", 2, "maximum parallel downloads from a single host, 0 for no limit")
+	syncCmd.Flags().Bool("offline", false, "use the cached feeds and do not download episodes")
+	syncCmd.Flags().StringSlice("tag", nil, "only sync podcasts with one of these tags")
+	syncCmd.Flags().Bool("checkpoint-episodes", false, "save the state of each episode as it is downloaded")
+	syncCmd.Flags().String("rate-limit", "", "total download bandwidth per second, for example 500KB, overrides the config")
+	syncCmd.Flags().Bool("dry-run", false, "show what sync would do without changing anything")
+	syncCmd.Flags().StringP("output", "o", "table", "output format for --dry-run, table or json")
 }
@@ -1,15 +1,23 @@
 package cmd
 
 import (
+	"bytes"
 	"castigate/feed"
+	"context"
+	"encoding/pem"
 	"errors"
 	"fmt"
 	"github.com/gorilla/feeds"
+	"github.com/spf13/cobra"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -58,6 +66,9 @@ func GetRSS(url string, t *testing.T) string {
 	return rssText
 }
 
+// testAsset is served for every episode, an ID3 header is enough to pass validation
+const testAsset = "ID3\x04\x00\x00\x00\x00\x00\x00asset"
+
 func CreateTestServer(t *testing.T) *httptest.Server {
 	mux := http.NewServeMux()
 	ts := httptest.NewServer(mux)
@@ -65,7 +76,8 @@ func CreateTestServer(t *testing.T) *httptest.Server {
 		res.Write([]byte(GetRSS(ts.URL, t)))
 	})
 	mux.HandleFunc("/", func(res http.ResponseWriter, req *http.Request) {
-		res.Write([]byte("asset"))
+		res.Header().Set("Content-Type", "audio/mpeg")
+		res.Write([]byte(testAsset))
 	})
 
 	return ts
@@ -82,8 +94,8 @@ func TestParseRSS(t *testing.T) {
 	}
 	tests := []testData{
 		{ts.URL + "/rss", GetRSS(ts.URL, t)},
-		{ts.URL + "/b/asset/foo.mp3", "asset"},
-		{ts.URL + "/b/artwork/art.jpg", "asset"},
+		{ts.URL + "/b/asset/foo.mp3", testAsset},
+		{ts.URL + "/b/artwork/art.jpg", testAsset},
 	}
 
 	for _, test := range tests {
@@ -133,7 +145,7 @@ func TestGetFeed(t *testing.T) {
 		DefaultCountToKeep: 10,
 	}
 	podcast := config.Podcasts[0]
-	err = podcast.Sync(config, "")
+	err = podcast.Sync(context.Background(), config, "")
 	if err != nil {
 		t.Fatalf("could not sync podcast: %v", err)
 	}
@@ -165,7 +177,7 @@ func TestGetFeed(t *testing.T) {
 	}
 
 	// sync again checking to see if we download more
-	err = podcast.Sync(config, "")
+	err = podcast.Sync(context.Background(), config, "")
 	if err != nil {
 		t.Fatalf("could not sync podcast: %v", err)
 	}
@@ -186,7 +198,7 @@ func TestGetFeed(t *testing.T) {
 		os.Remove(filepath.Join(dir, filename))
 	}
 
-	_, err = podcast.UpdateFromRSS(config)
+	_, err = podcast.UpdateFromRSS(context.Background(), config, "")
 	if err != nil {
 		t.Fatalf("could not sync podcast: %v", err)
 	}
@@ -200,7 +212,7 @@ func TestGetFeed(t *testing.T) {
 		t.Errorf("expected 0 got %d", podcast.GetDeletedCount())
 	}
 
-	err = podcast.Sync(config, "")
+	err = podcast.Sync(context.Background(), config, "")
 	if err != nil {
 		t.Fatalf("could not sync podcast: %v", err)
 	}
@@ -224,3 +236,1747 @@ func FileExists(fn string) bool {
 	return true
 
 }
+
+// RunCommand runs the command given by args with the config file fn, after
+// resetting the flags of every command, and returns its output.
+func RunCommand(t *testing.T, fn string, args ...string) string {
+	t.Helper()
+	resetAllFlags(rootCmd)
+	buffer := new(bytes.Buffer)
+	rootCmd.SetOut(buffer)
+	rootCmd.SetErr(buffer)
+	rootCmd.SetArgs(append([]string{"--config", fn}, args...))
+	err := rootCmd.Execute()
+	if err != nil {
+		t.Fatalf("error running %v: %v", args, err)
+	}
+	return buffer.String()
+}
+
+func resetAllFlags(cmd *cobra.Command) {
+	ResetFlags(cmd)
+	for _, child := range cmd.Commands() {
+		resetAllFlags(child)
+	}
+}
+
+// LoadPodcast reloads the config and returns it with the podcast named label.
+func LoadPodcast(t *testing.T, backend *feed.FileBackend, label string) (*feed.Podcast, feed.Config) {
+	t.Helper()
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	podcast, err := config.FindPodcast(label)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return podcast, config
+}
+
+// RunSync runs the sync command with args, then reloads the config and returns
+// it with the podcast named label.
+func RunSync(t *testing.T, fn string, backend *feed.FileBackend, label string, args ...string) (*feed.Podcast, feed.Config) {
+	t.Helper()
+	RunCommand(t, fn, append([]string{"sync"}, args...)...)
+	return LoadPodcast(t, backend, label)
+}
+
+func TestSyncParallel(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(syncCmd)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// count the downloads in flight, the short sleep keeps them overlapping
+	var inFlight, peak int32
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/rss", func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(GetRSS(ts.URL, t)))
+	})
+	mux.HandleFunc("/", func(res http.ResponseWriter, req *http.Request) {
+		current := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			old := atomic.LoadInt32(&peak)
+			if current <= old || atomic.CompareAndSwapInt32(&peak, old, current) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		res.Header().Set("Content-Type", "audio/mpeg")
+		res.Write([]byte(testAsset))
+	})
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheDirectory = filepath.Join(dir, "cache")
+	for _, label := range []string{"first", "second", "third"} {
+		config.Podcasts = append(config.Podcasts, &feed.Podcast{
+			Label:       label,
+			Feed:        ts.URL + "/rss",
+			Directory:   filepath.Join(dir, label),
+			CountToKeep: 5,
+			Start:       "newest",
+			Episodes:    make(map[string]*feed.Episode, 0),
+		})
+	}
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, config = RunSync(t, fn, &backend, "first", "--jobs", "4", "--jobs-per-host", "2")
+	// every podcast is on the same host, so the host limit caps the downloads
+	if peak := atomic.LoadInt32(&peak); peak <= 1 || peak > 2 {
+		t.Errorf("expected parallel downloads, at most 2 in flight, got a peak of %d", peak)
+	}
+
+	for _, podcast := range config.Podcasts {
+		if podcast.GetDownloadedCount() != 5 {
+			t.Errorf("%s: expected 5 downloaded got %d", podcast.Label, podcast.GetDownloadedCount())
+		}
+		// newest first, so the last five episodes of the feed are downloaded
+		for count := 95; count < 100; count++ {
+			episode := podcast.Episodes[fmt.Sprintf("episode-%03d", count)]
+			if episode == nil || episode.State != feed.Downloaded {
+				t.Errorf("%s: expected episode-%03d to be downloaded", podcast.Label, count)
+			}
+		}
+		playlist, err := os.ReadFile(filepath.Join(podcast.Directory, "test.m3u"))
+		if err != nil {
+			t.Fatalf("could not read playlist: %v", err)
+		}
+		expected := ""
+		for count := 99; count >= 95; count-- {
+			expected += fmt.Sprintf("2020-%s-00-00-00-episode-%03d-this-is-episode--%d.mp3\n",
+				time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, count).Format("01-02"), count, count)
+		}
+		if string(playlist) != expected {
+			t.Errorf("%s: unexpected playlist\nexpected:\n%s\nactual:\n%s", podcast.Label, expected, playlist)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	limiter := feed.NewLimiter(3, 2)
+	if limiter.Jobs() != 3 {
+		t.Errorf("expected 3 jobs got %d", limiter.Jobs())
+	}
+	if feed.NewLimiter(0, 0).Jobs() != 1 {
+		t.Errorf("expected at least one job")
+	}
+
+	acquired := make(chan string, 10)
+	acquire := func(rawURL string) func() {
+		release := make(chan struct{})
+		go func() {
+			done := limiter.Acquire(rawURL)
+			acquired <- rawURL
+			<-release
+			done()
+		}()
+		return func() { close(release) }
+	}
+	expectAcquired := func(expected string) {
+		select {
+		case rawURL := <-acquired:
+			if rawURL != expected {
+				t.Errorf("expected %s to start got %s", expected, rawURL)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %s to start", expected)
+		}
+	}
+	expectBlocked := func() {
+		select {
+		case rawURL := <-acquired:
+			t.Errorf("expected no download to start got %s", rawURL)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	releaseFirst := acquire("http://a.example.com/1.mp3")
+	expectAcquired("http://a.example.com/1.mp3")
+	releaseSecond := acquire("http://a.example.com/2.mp3")
+	expectAcquired("http://a.example.com/2.mp3")
+	// the host limit blocks a third download from the same host
+	releaseThird := acquire("http://a.example.com/3.mp3")
+	expectBlocked()
+	// another host is only limited by the jobs
+	releaseOther := acquire("http://b.example.com/1.mp3")
+	expectAcquired("http://b.example.com/1.mp3")
+	releaseLast := acquire("http://c.example.com/1.mp3")
+	expectBlocked()
+
+	releaseOther()
+	expectAcquired("http://c.example.com/1.mp3")
+	releaseFirst()
+	expectAcquired("http://a.example.com/3.mp3")
+	releaseSecond()
+	releaseThird()
+	releaseLast()
+}
+
+func TestResumeDownload(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	content := make([]byte, 64*1024)
+	for index := range content {
+		content[index] = byte(index % 251)
+	}
+	copy(content, "ID3")
+	requests := 0
+	ranges := make([]string, 0)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/episode.mp3", func(res http.ResponseWriter, req *http.Request) {
+		requests++
+		ranges = append(ranges, req.Header.Get("Range"))
+		res.Header().Set("ETag", `"episode-v1"`)
+		if requests == 1 {
+			// send half of the episode then drop the connection
+			res.Header().Set("Content-Length", fmt.Sprintf("%d", len(content)))
+			res.Write(content[:len(content)/2])
+			res.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(res, req, "episode.mp3", time.Time{}, bytes.NewReader(content))
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	episode := feed.Episode{GUID: "episode", URL: ts.URL + "/episode.mp3", Filename: "episode.mp3"}
+	fn := filepath.Join(dir, episode.Filename)
+	err = episode.Download(context.Background(), nil, fn)
+	if err != nil {
+		t.Fatalf("could not download episode: %v", err)
+	}
+	downloaded, err := os.ReadFile(fn)
+	if err != nil {
+		t.Fatalf("could not read episode: %v", err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Errorf("downloaded episode does not match, got %d bytes expected %d", len(downloaded), len(content))
+	}
+	if requests != 2 || ranges[1] != fmt.Sprintf("bytes=%d-", len(content)/2) {
+		t.Errorf("expected the second request to resume at %d, got requests %v", len(content)/2, ranges)
+	}
+	if FileExists(fn + feed.PartialSuffix) {
+		t.Errorf("partial file %s was not removed", fn+feed.PartialSuffix)
+	}
+
+	// a partial file left by an earlier run is resumed
+	os.Remove(fn)
+	os.WriteFile(fn+feed.PartialSuffix, content[:1000], 0644)
+	os.WriteFile(fn+feed.PartialSuffix+".validator", []byte(`"episode-v1"`), 0644)
+	err = episode.Download(context.Background(), nil, fn)
+	if err != nil {
+		t.Fatalf("could not download episode: %v", err)
+	}
+	downloaded, _ = os.ReadFile(fn)
+	if !bytes.Equal(downloaded, content) {
+		t.Errorf("resumed episode does not match, got %d bytes expected %d", len(downloaded), len(content))
+	}
+	if ranges[len(ranges)-1] != "bytes=1000-" {
+		t.Errorf("expected the download to resume at byte 1000, got %s", ranges[len(ranges)-1])
+	}
+}
+
+func TestValidateDownload(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/rss", func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(GetRSS(ts.URL, t)))
+	})
+	mux.HandleFunc("/asset/episode-000.mp3", func(res http.ResponseWriter, req *http.Request) {
+		http.NotFound(res, req)
+	})
+	mux.HandleFunc("/asset/episode-001.mp3", func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "text/html")
+		res.Write([]byte("<html><body>please log in</body></html>"))
+	})
+	mux.HandleFunc("/asset/episode-002.mp3", func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "audio/mpeg")
+		res.Write([]byte("not really audio"))
+	})
+	mux.HandleFunc("/asset/episode-003.mp3", func(res http.ResponseWriter, req *http.Request) {
+		http.Error(res, "gone", http.StatusGone)
+	})
+	mux.HandleFunc("/", func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "audio/mpeg")
+		res.Write([]byte(testAsset))
+	})
+
+	config := feed.NewConfig()
+	config.QuarantineDirectory = filepath.Join(dir, "quarantine")
+	config.CacheDirectory = filepath.Join(dir, "cache")
+	config.Podcasts = []*feed.Podcast{
+		{
+			Label:       "test",
+			Feed:        ts.URL + "/rss",
+			Directory:   filepath.Join(dir, "test"),
+			CountToKeep: 3,
+			Start:       "oldest",
+		},
+	}
+	podcast := config.Podcasts[0]
+	err = podcast.Sync(context.Background(), config, "")
+	if err != nil {
+		t.Fatalf("could not sync podcast: %v", err)
+	}
+	// a 404, a captive portal or a broken file may be fixed, they are tried again later
+	for count := 0; count < 3; count++ {
+		episode := podcast.Episodes[fmt.Sprintf("episode-%03d", count)]
+		if episode.State != feed.New || episode.Attempts != 1 || !episode.NextAttempt.After(time.Now()) {
+			t.Errorf("expected episode-%03d to be retried later, state is %v after %d attempts", count, episode.State, episode.Attempts)
+		}
+		if FileExists(filepath.Join(podcast.Directory, episode.Filename)) {
+			t.Errorf("invalid episode %s was saved", episode.Filename)
+		}
+	}
+	if gone := podcast.Episodes["episode-003"]; gone.State != feed.Failed || gone.LastStatus != http.StatusGone {
+		t.Errorf("expected the episode that is gone to have failed, state is %v", gone.State)
+	}
+	// the replacements are downloaded in order
+	for count := 4; count < 7; count++ {
+		episode := podcast.Episodes[fmt.Sprintf("episode-%03d", count)]
+		if episode.State != feed.Downloaded {
+			t.Errorf("expected episode-%03d to be downloaded, state is %v", count, episode.State)
+		}
+	}
+	quarantined, err := filepath.Glob(filepath.Join(dir, "quarantine", "test", "*.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(quarantined) != 2 {
+		t.Errorf("expected 2 quarantined files, got %v", quarantined)
+	}
+}
+
+func TestFeedCache(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fetches := 0
+	notModified := 0
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	mux.HandleFunc("/rss", func(res http.ResponseWriter, req *http.Request) {
+		fetches++
+		if req.Header.Get("If-None-Match") == `"feed-v1"` {
+			notModified++
+			res.WriteHeader(http.StatusNotModified)
+			return
+		}
+		res.Header().Set("ETag", `"feed-v1"`)
+		res.Write([]byte(GetRSS(ts.URL, t)))
+	})
+	mux.HandleFunc("/", func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "audio/mpeg")
+		res.Write([]byte(testAsset))
+	})
+
+	config := feed.NewConfig()
+	config.CacheDirectory = filepath.Join(dir, "cache")
+	config.Podcasts = []*feed.Podcast{
+		{
+			Label:       "test",
+			Feed:        ts.URL + "/rss",
+			Directory:   filepath.Join(dir, "test"),
+			CountToKeep: 2,
+			Start:       "oldest",
+		},
+	}
+	podcast := config.Podcasts[0]
+	for sync := 0; sync < 2; sync++ {
+		err = podcast.Sync(context.Background(), config, "")
+		if err != nil {
+			t.Fatalf("could not sync podcast: %v", err)
+		}
+	}
+	if fetches != 2 || notModified != 1 {
+		t.Errorf("expected the second fetch to be not modified, got %d fetches and %d not modified", fetches, notModified)
+	}
+	if podcast.ETag != `"feed-v1"` {
+		t.Errorf("expected the ETag to be saved, got %s", podcast.ETag)
+	}
+	if !FileExists(podcast.CacheFile(config, "")) {
+		t.Fatalf("feed was not cached in %s", podcast.CacheFile(config, ""))
+	}
+
+	// rebuild the state from the cache without the server
+	ts.Close()
+	os.Remove(filepath.Join(podcast.Directory, "test.m3u"))
+	config.Offline = true
+	offline := &feed.Podcast{
+		Label:     "test",
+		Feed:      podcast.Feed,
+		Directory: podcast.Directory,
+	}
+	err = offline.Sync(context.Background(), config, "")
+	if err != nil {
+		t.Fatalf("could not sync podcast offline: %v", err)
+	}
+	if len(offline.Episodes) != 100 {
+		t.Errorf("expected 100 episodes from the cached feed, got %d", len(offline.Episodes))
+	}
+	if offline.GetDownloadedCount() != 0 || offline.GetNewCount() != 100 {
+		t.Errorf("expected no downloads offline, got %d downloaded", offline.GetDownloadedCount())
+	}
+	if !FileExists(filepath.Join(podcast.Directory, "test.m3u")) {
+		t.Errorf("playlist was not written offline")
+	}
+}
+
+func TestSyncCancel(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	started := make(chan struct{})
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/rss", func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(GetRSS(ts.URL, t)))
+	})
+	mux.HandleFunc("/asset/episode-001.mp3", func(res http.ResponseWriter, req *http.Request) {
+		// start the download and stall until the client goes away
+		res.Header().Set("Content-Type", "audio/mpeg")
+		res.Header().Set("Content-Length", "100000")
+		res.Write([]byte(testAsset))
+		res.(http.Flusher).Flush()
+		close(started)
+		<-req.Context().Done()
+	})
+	mux.HandleFunc("/", func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "audio/mpeg")
+		res.Write([]byte(testAsset))
+	})
+
+	config := feed.NewConfig()
+	config.CacheDirectory = ""
+	config.Podcasts = []*feed.Podcast{
+		{
+			Label:       "test",
+			Feed:        ts.URL + "/rss",
+			Directory:   filepath.Join(dir, "test"),
+			CountToKeep: 3,
+			Start:       "oldest",
+		},
+	}
+	podcast := config.Podcasts[0]
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+	err = podcast.Sync(ctx, config, "")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the sync to be cancelled, got %v", err)
+	}
+	episode := podcast.Episodes["episode-001"]
+	if episode.State != feed.New {
+		t.Errorf("expected the cancelled episode to still be new, state is %v", episode.State)
+	}
+	partials, err := filepath.Glob(filepath.Join(podcast.Directory, "*"+feed.PartialSuffix+"*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(partials) != 0 {
+		t.Errorf("partial files were left behind: %v", partials)
+	}
+	// the playlist holds whatever was downloaded before the cancel
+	playlist, err := os.ReadFile(filepath.Join(podcast.Directory, "test.m3u"))
+	if err != nil {
+		t.Fatalf("could not read playlist: %v", err)
+	}
+	expected := ""
+	for _, episode := range []string{"episode-000", "episode-002"} {
+		if podcast.Episodes[episode].State == feed.Downloaded {
+			expected += podcast.Episodes[episode].Filename + "\n"
+		}
+	}
+	if string(playlist) != expected {
+		t.Errorf("unexpected playlist\nexpected:\n%s\nactual:\n%s", expected, playlist)
+	}
+}
+
+func TestSyncJournal(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer os.Remove(fn + feed.JournalSuffix)
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Podcasts = append(config.Podcasts, &feed.Podcast{
+		Label:    "test",
+		Feed:     "http://feed.example.com",
+		Episodes: map[string]*feed.Episode{"one": {GUID: "one", State: feed.New}},
+	}, &feed.Podcast{
+		Label:    "other",
+		Feed:     "http://other.example.com",
+		Episodes: map[string]*feed.Episode{},
+	})
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// checkpoint an episode and a podcast, then crash in the middle of a write
+	podcast := config.Podcasts[0]
+	podcast.Episodes["one"].State = feed.Downloaded
+	err = backend.CheckpointEpisode(podcast, "one", podcast.Episodes["one"])
+	if err != nil {
+		t.Fatalf("could not checkpoint episode: %v", err)
+	}
+	other := config.Podcasts[1]
+	other.Title = "Other"
+	other.Episodes["two"] = &feed.Episode{GUID: "two", State: feed.Downloaded}
+	err = backend.CheckpointPodcast(other)
+	if err != nil {
+		t.Fatalf("could not checkpoint podcast: %v", err)
+	}
+	journal, err := os.OpenFile(fn+feed.JournalSuffix, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	journal.WriteString(`{"Label":"test","Key":"one","Episode":{"GUID":"one","St`)
+	journal.Close()
+
+	reloaded := feed.FileBackend{}
+	reloaded.Init(fn)
+	config, err = reloaded.Load()
+	if err != nil {
+		t.Fatalf("could not load config with journal: %v", err)
+	}
+	podcast, _ = config.FindPodcast("test")
+	if podcast.Episodes["one"].State != feed.Downloaded {
+		t.Errorf("expected the checkpointed episode to be downloaded, state is %v", podcast.Episodes["one"].State)
+	}
+	other, _ = config.FindPodcast("other")
+	if other.Title != "Other" || other.Episodes["two"] == nil {
+		t.Errorf("expected the checkpointed podcast to be restored, got %#v", other)
+	}
+
+	err = reloaded.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FileExists(fn + feed.JournalSuffix) {
+		t.Errorf("journal was not removed when the config was saved")
+	}
+}
+
+func TestSyncSelected(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(syncCmd)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ts := CreateTestServer(t)
+	defer ts.Close()
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheDirectory = ""
+	tags := map[string][]string{"kids": {"kids"}, "news": {"news", "daily"}, "long": nil}
+	for _, label := range []string{"kids", "news", "long"} {
+		config.Podcasts = append(config.Podcasts, &feed.Podcast{
+			Label:       label,
+			Feed:        ts.URL + "/rss",
+			Directory:   filepath.Join(dir, label),
+			CountToKeep: 1,
+			Tags:        tags[label],
+			Episodes:    make(map[string]*feed.Episode, 0),
+		})
+	}
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, config = RunSync(t, fn, &backend, "kids", "--tag", "daily", "kids")
+	expected := map[string]int{"kids": 100, "news": 100, "long": 0}
+	for _, podcast := range config.Podcasts {
+		if len(podcast.Episodes) != expected[podcast.Label] {
+			t.Errorf("%s: expected %d episodes, got %d", podcast.Label, expected[podcast.Label], len(podcast.Episodes))
+		}
+	}
+}
+
+func TestSyncHTTPConfig(t *testing.T) {
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	mux := http.NewServeMux()
+	ts := httptest.NewTLSServer(mux)
+	defer ts.Close()
+	rejected := 0
+	check := func(req *http.Request) bool {
+		cookie, err := req.Cookie("session")
+		if req.UserAgent() != "castigate-test" || req.Header.Get("X-Token") != "podcast" || err != nil || cookie.Value != "secret" {
+			rejected++
+			return false
+		}
+		return true
+	}
+	mux.HandleFunc("/rss", func(res http.ResponseWriter, req *http.Request) {
+		if !check(req) {
+			http.Error(res, "forbidden", http.StatusForbidden)
+			return
+		}
+		res.Write([]byte(GetRSS(ts.URL, t)))
+	})
+	mux.HandleFunc("/", func(res http.ResponseWriter, req *http.Request) {
+		if !check(req) {
+			http.Error(res, "forbidden", http.StatusForbidden)
+			return
+		}
+		res.Header().Set("Content-Type", "audio/mpeg")
+		res.Write([]byte(testAsset))
+	})
+
+	// trust the test server's certificate through a CA bundle
+	bundle := filepath.Join(dir, "ca.pem")
+	certificate := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	err = os.WriteFile(bundle, certificate, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := feed.NewConfig()
+	config.CacheDirectory = ""
+	config.HTTP.UserAgent = "castigate-test"
+	config.HTTP.Headers = map[string]string{"X-Token": "global"}
+	config.Podcasts = []*feed.Podcast{
+		{
+			Label:       "test",
+			Feed:        ts.URL + "/rss",
+			Directory:   filepath.Join(dir, "test"),
+			CountToKeep: 2,
+			HTTP: &feed.HTTPConfig{
+				CABundle: bundle,
+				Headers:  map[string]string{"X-Token": "podcast"},
+				Cookies:  map[string]string{"session": "secret"},
+			},
+		},
+	}
+	podcast := config.Podcasts[0]
+	err = podcast.Sync(context.Background(), config, "")
+	if err != nil {
+		t.Fatalf("could not sync podcast: %v", err)
+	}
+	if podcast.GetDownloadedCount() != 2 || rejected != 0 {
+		t.Errorf("expected 2 downloads and no rejected requests, got %d downloads and %d rejected", podcast.GetDownloadedCount(), rejected)
+	}
+
+	// without the CA bundle the certificate is not trusted
+	podcast.HTTP.CABundle = ""
+	_, err = podcast.UpdateFromRSS(context.Background(), config, "")
+	if err == nil {
+		t.Errorf("expected an untrusted certificate to fail")
+	}
+}
+
+func TestHTTPReadTimeout(t *testing.T) {
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/", func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(testAsset))
+		res.(http.Flusher).Flush()
+		select {
+		case <-req.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	})
+
+	settings := feed.HTTPConfig{ReadTimeout: 200 * time.Millisecond}
+	client, err := settings.NewClient("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request, err := http.NewRequest(http.MethodGet, ts.URL+"/episode.mp3", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	resp, err := client.Do(request)
+	if err != nil {
+		t.Fatalf("expected a response, got %v", err)
+	}
+	_, err = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err == nil {
+		t.Errorf("expected the read to time out")
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("read timeout took %s", time.Since(start))
+	}
+}
+
+func TestSyncDownloadWindows(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(syncCmd)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ts := CreateTestServer(t)
+	defer ts.Close()
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheDirectory = ""
+	// A window starting in two hours, so now is outside of it
+	now := time.Now()
+	window := now.Add(2*time.Hour).Format("15:04") + "-" + now.Add(3*time.Hour).Format("15:04")
+	config.Podcasts = append(config.Podcasts, &feed.Podcast{
+		Label:           "windowed",
+		Feed:            ts.URL + "/rss",
+		Directory:       dir,
+		CountToKeep:     2,
+		DownloadWindows: []string{window},
+		Episodes:        make(map[string]*feed.Episode, 0),
+	})
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	podcast, config := RunSync(t, fn, &backend, "windowed")
+	if len(podcast.Episodes) != 100 {
+		t.Errorf("expected the feed to be refreshed, got %d episodes", len(podcast.Episodes))
+	}
+	if podcast.GetDownloadedCount() != 0 {
+		t.Errorf("expected no downloads outside the window, got %d", podcast.GetDownloadedCount())
+	}
+
+	// A window around now allows downloads
+	podcast.DownloadWindows = []string{now.Add(-time.Hour).Format("15:04") + "-" + now.Add(time.Hour).Format("15:04")}
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	podcast, _ = RunSync(t, fn, &backend, "windowed")
+	if count := podcast.GetDownloadedCount(); count != 2 {
+		t.Errorf("expected 2 downloads inside the window, got %d", count)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	limit, err := feed.ParseByteSize("32KiB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if limit != 32*1024 || limit.String() != "32KiB" {
+		t.Errorf("expected 32KiB, got %d (%s)", limit, limit)
+	}
+	for _, size := range []string{"1.5MB", "10", "2G"} {
+		if _, err := feed.ParseByteSize(size); err != nil {
+			t.Errorf("could not parse %s: %v", size, err)
+		}
+	}
+	if _, err := feed.ParseByteSize("fast"); err == nil {
+		t.Errorf("expected an error parsing fast")
+	}
+
+	content := make([]byte, 64*1024)
+	copy(content, "ID3")
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/", func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "audio/mpeg")
+		res.Write(content)
+	})
+
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	client := (*feed.HTTPClient)(nil).WithRateLimiters(feed.NewRateLimiter(limit))
+	episode := feed.Episode{URL: ts.URL + "/episode.mp3"}
+	start := time.Now()
+	err = episode.Download(context.Background(), client, filepath.Join(dir, "episode.mp3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// One second of burst, then 32KiB more at 32KiB per second
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Errorf("expected the download to be rate limited, took %s", elapsed)
+	}
+}
+
+func TestEpisodeStates(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(syncCmd)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ts := CreateTestServer(t)
+	defer ts.Close()
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheDirectory = ""
+	config.Podcasts = append(config.Podcasts, &feed.Podcast{
+		Label:       "states",
+		Feed:        ts.URL + "/rss",
+		Directory:   dir,
+		CountToKeep: 2,
+		Start:       "oldest",
+		Episodes:    make(map[string]*feed.Episode, 0),
+	})
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	podcast, config := RunSync(t, fn, &backend, "states")
+	pinned := podcast.Episodes["episode-000"]
+	skipped := podcast.Episodes["episode-002"]
+	if err := pinned.Transition(feed.Pinned, "keep"); err != nil {
+		t.Fatal(err)
+	}
+	if err := skipped.Transition(feed.Skipped, "not interested"); err != nil {
+		t.Fatal(err)
+	}
+	if err := skipped.Transition(feed.Downloaded, "invalid"); err == nil {
+		t.Errorf("expected skipped to downloaded to be rejected")
+	}
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the pinned episode does not count against CountToKeep and the skipped one is passed over
+	podcast, _ = RunSync(t, fn, &backend, "states")
+	expected := map[string]feed.EpisodeState{
+		"episode-000": feed.Pinned,
+		"episode-001": feed.Downloaded,
+		"episode-002": feed.Skipped,
+		"episode-003": feed.Downloaded,
+		"episode-004": feed.New,
+	}
+	for guid, state := range expected {
+		if podcast.Episodes[guid].State != state {
+			t.Errorf("expected %s to be %v, got %v", guid, state, podcast.Episodes[guid].State)
+		}
+	}
+	playlist, err := os.ReadFile(filepath.Join(dir, podcast.PlaylistFilename()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPlaylist := ""
+	for _, guid := range []string{"episode-000", "episode-001", "episode-003"} {
+		expectedPlaylist += podcast.Episodes[guid].Filename + "\n"
+	}
+	if string(playlist) != expectedPlaylist {
+		t.Errorf("unexpected playlist\nexpected:\n%s\nactual:\n%s", expectedPlaylist, playlist)
+	}
+	history := podcast.Episodes["episode-000"].History
+	if len(history) != 2 || history[0].To != feed.Downloaded || history[1].To != feed.Pinned || history[1].Reason != "keep" {
+		t.Errorf("unexpected history %+v", history)
+	}
+
+	// a pinned episode whose file is removed is deleted
+	os.Remove(filepath.Join(dir, podcast.Episodes["episode-000"].Filename))
+	podcast, _ = RunSync(t, fn, &backend, "states")
+	episode := podcast.Episodes["episode-000"]
+	if episode.State != feed.Deleted || episode.History[len(episode.History)-1].From != feed.Pinned {
+		t.Errorf("expected the pinned episode to be deleted, got %v %+v", episode.State, episode.History)
+	}
+}
+
+func TestSyncRetryBackoff(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(syncCmd)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/rss", func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(GetRSS(ts.URL, t)))
+	})
+	mux.HandleFunc("/asset/episode-000.mp3", func(res http.ResponseWriter, req *http.Request) {
+		http.Error(res, "down for maintenance", http.StatusServiceUnavailable)
+	})
+	mux.HandleFunc("/", func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "audio/mpeg")
+		res.Write([]byte(testAsset))
+	})
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheDirectory = ""
+	config.MaxAttempts = 2
+	config.RetryBackoff = 0
+	config.Podcasts = append(config.Podcasts, &feed.Podcast{
+		Label:       "retry",
+		Feed:        ts.URL + "/rss",
+		Directory:   dir,
+		CountToKeep: 1,
+		Start:       "oldest",
+		Episodes:    make(map[string]*feed.Episode, 0),
+	})
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the failing episode is recorded and the next one downloaded instead
+	podcast, _ := RunSync(t, fn, &backend, "retry")
+	failing := podcast.Episodes["episode-000"]
+	if failing.State != feed.New || failing.Attempts != 1 || failing.LastStatus != http.StatusServiceUnavailable || failing.LastError == "" {
+		t.Errorf("expected one failed attempt with status 503, got %v %d %d %q", failing.State, failing.Attempts, failing.LastStatus, failing.LastError)
+	}
+	if podcast.Episodes["episode-001"].State != feed.Downloaded {
+		t.Errorf("expected episode-001 to be downloaded, got %v", podcast.Episodes["episode-001"].State)
+	}
+
+	// the second attempt reaches MaxAttempts
+	os.Remove(filepath.Join(dir, podcast.Episodes["episode-001"].Filename))
+	podcast, _ = RunSync(t, fn, &backend, "retry")
+	failing = podcast.Episodes["episode-000"]
+	if failing.State != feed.Failed || failing.Attempts != 2 {
+		t.Errorf("expected episode-000 to fail after 2 attempts, got %v after %d", failing.State, failing.Attempts)
+	}
+	if podcast.Episodes["episode-002"].State != feed.Downloaded {
+		t.Errorf("expected episode-002 to be downloaded, got %v", podcast.Episodes["episode-002"].State)
+	}
+	details := podcast.PrintDetails()
+	if !strings.Contains(details, "Failed: 1") || !strings.Contains(details, "failed after 2 attempts, status 503") {
+		t.Errorf("expected the failure in the details, got\n%s", details)
+	}
+
+	// the backoff doubles with each attempt and delays the next try
+	episode := feed.Episode{GUID: "backoff", State: feed.New}
+	episode.RecordFailure(errors.New("timeout"), false, 5, time.Hour)
+	episode.RecordFailure(errors.New("timeout"), false, 5, time.Hour)
+	wait := time.Until(episode.NextAttempt)
+	if wait < 119*time.Minute || wait > 2*time.Hour {
+		t.Errorf("expected the next attempt in 2 hours, got %s", wait)
+	}
+	if episode.Eligible(time.Now()) || !episode.Eligible(time.Now().Add(3*time.Hour)) {
+		t.Errorf("expected the episode to be eligible only after its backoff")
+	}
+}
+
+func TestSyncArchive(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(syncCmd)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ts := CreateTestServer(t)
+	defer ts.Close()
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheDirectory = ""
+	config.ArchiveBatchSize = 40
+	config.ArchiveDelay = 10 * time.Millisecond
+	config.Podcasts = append(config.Podcasts, &feed.Podcast{
+		Label:       "archive",
+		Feed:        ts.URL + "/rss",
+		Directory:   dir,
+		CountToKeep: 1,
+		Start:       "oldest",
+		Mode:        feed.ModeArchive,
+		Episodes:    make(map[string]*feed.Episode, 0),
+	})
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a batch is downloaded, whatever the count to keep, and paced
+	start := time.Now()
+	podcast, _ := RunSync(t, fn, &backend, "archive")
+	if podcast.GetDownloadedCount() != 40 {
+		t.Errorf("expected a batch of 40 downloads, got %d", podcast.GetDownloadedCount())
+	}
+	if elapsed := time.Since(start); elapsed < 39*config.ArchiveDelay {
+		t.Errorf("expected the downloads to be paced, took %s", elapsed)
+	}
+	if !strings.Contains(podcast.PrintDetails(), "Mode: archive, 60 episodes in the backlog") {
+		t.Errorf("expected the backlog in the details, got\n%s", podcast.PrintDetails())
+	}
+
+	// files moved to other storage are not deleted and left out of the playlist
+	for count := 0; count < 5; count++ {
+		os.Remove(filepath.Join(dir, podcast.Episodes[fmt.Sprintf("episode-%03d", count)].Filename))
+	}
+	podcast, _ = RunSync(t, fn, &backend, "archive")
+	if podcast.GetDownloadedCount() != 80 || podcast.GetDeletedCount() != 0 {
+		t.Errorf("expected 80 downloaded and none deleted, got %d and %d", podcast.GetDownloadedCount(), podcast.GetDeletedCount())
+	}
+	playlist, err := os.ReadFile(filepath.Join(dir, podcast.PlaylistFilename()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(playlist), "\n"); lines != 75 {
+		t.Errorf("expected 75 episodes in the playlist, got %d", lines)
+	}
+
+	podcast, _ = RunSync(t, fn, &backend, "archive")
+	if podcast.GetDownloadedCount() != 100 || podcast.GetNewCount() != 0 {
+		t.Errorf("expected the whole catalogue, got %d downloaded and %d new", podcast.GetDownloadedCount(), podcast.GetNewCount())
+	}
+}
+
+func TestSyncPrune(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(syncCmd)
+	defer ResetFlags(planCmd)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ts := CreateTestServer(t)
+	defer ts.Close()
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheDirectory = ""
+	config.TrashDirectory = filepath.Join(dir, "trash")
+	config.Podcasts = append(config.Podcasts, &feed.Podcast{
+		Label:       "news",
+		Feed:        ts.URL + "/rss",
+		Directory:   filepath.Join(dir, "news"),
+		CountToKeep: 3,
+		Start:       "oldest",
+		Episodes:    make(map[string]*feed.Episode, 0),
+	})
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	podcast, config := RunSync(t, fn, &backend, "news")
+
+	// switching to the newest episodes, the old downloads are pruned
+	podcast.Start = "newest"
+	podcast.Prune = true
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := RunCommand(t, fn, "plan")
+	if strings.Count(output, "  download  ") != 3 || strings.Count(output, "  prune  ") != 3 {
+		t.Errorf("expected 3 downloads and 3 prunes in the plan\n%s", output)
+	}
+
+	podcast, _ = RunSync(t, fn, &backend, "news")
+	for count := 0; count < 100; count++ {
+		episode := podcast.Episodes[fmt.Sprintf("episode-%03d", count)]
+		switch {
+		case count < 3:
+			if episode.State != feed.Deleted || !strings.HasPrefix(episode.History[len(episode.History)-1].Reason, "pruned") {
+				t.Errorf("expected episode-%03d to be pruned, got %v %+v", count, episode.State, episode.History)
+			}
+			if FileExists(filepath.Join(dir, "news", episode.Filename)) || !FileExists(filepath.Join(dir, "trash", "news", episode.Filename)) {
+				t.Errorf("expected episode-%03d to be moved to the trash", count)
+			}
+		case count >= 97:
+			if episode.State != feed.Downloaded {
+				t.Errorf("expected episode-%03d to be downloaded, got %v", count, episode.State)
+			}
+		default:
+			if episode.State != feed.New {
+				t.Errorf("expected episode-%03d to be new, got %v", count, episode.State)
+			}
+		}
+	}
+
+	// working through the back catalogue, pruning still removes the oldest downloads
+	config, err = backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.Podcasts = append(config.Podcasts, &feed.Podcast{
+		Label:       "backlog",
+		Feed:        ts.URL + "/rss",
+		Directory:   filepath.Join(dir, "backlog"),
+		CountToKeep: 5,
+		Start:       "oldest",
+		Episodes:    make(map[string]*feed.Episode, 0),
+	})
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	podcast, config = RunSync(t, fn, &backend, "backlog", "backlog")
+	podcast.CountToKeep = 3
+	podcast.Prune = true
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	podcast, _ = RunSync(t, fn, &backend, "backlog", "backlog")
+	for count := 0; count < 5; count++ {
+		episode := podcast.Episodes[fmt.Sprintf("episode-%03d", count)]
+		if count < 2 && episode.State != feed.Deleted {
+			t.Errorf("expected episode-%03d to be pruned, got %v", count, episode.State)
+		}
+		if count >= 2 && episode.State != feed.Downloaded {
+			t.Errorf("expected episode-%03d to be kept, got %v", count, episode.State)
+		}
+	}
+}
+
+func TestSyncRetention(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(syncCmd)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ts := CreateTestServer(t)
+	defer ts.Close()
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheDirectory = ""
+	for _, label := range []string{"age", "bytes"} {
+		config.Podcasts = append(config.Podcasts, &feed.Podcast{
+			Label:       label,
+			Feed:        ts.URL + "/rss",
+			Directory:   filepath.Join(dir, label),
+			CountToKeep: 3,
+			Start:       "oldest",
+			Episodes:    make(map[string]*feed.Episode, 0),
+		})
+	}
+	config.Podcasts[1].CountToKeep = 5
+	config.Podcasts[1].MaxBytes = 2*feed.ByteSize(len(testAsset)) + 1
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the podcast's MaxBytes stops downloads, a download of unknown size may go over
+	podcast, config := RunSync(t, fn, &backend, "bytes", "bytes")
+	if podcast.GetDownloadedCount() != 3 {
+		t.Errorf("expected MaxBytes to stop after 3 downloads, got %d", podcast.GetDownloadedCount())
+	}
+
+	// episodes older than MaxAge are removed and not downloaded
+	podcast, config = RunSync(t, fn, &backend, "age", "age")
+	if podcast.GetDownloadedCount() != 3 || podcast.Episodes["episode-000"].State != feed.Downloaded {
+		t.Fatalf("expected the 3 oldest episodes to be downloaded, got %d", podcast.GetDownloadedCount())
+	}
+	config.Podcasts[0].MaxAge = time.Since(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, 96)) + time.Hour
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	podcast, config = RunSync(t, fn, &backend, "age", "age")
+	for count := 0; count < 100; count++ {
+		episode := podcast.Episodes[fmt.Sprintf("episode-%03d", count)]
+		expected := feed.New
+		if count < 3 {
+			expected = feed.Deleted
+		} else if count >= 96 && count < 99 {
+			expected = feed.Downloaded
+		}
+		if episode.State != expected {
+			t.Errorf("expected episode-%03d to be %v, got %v", count, expected, episode.State)
+		}
+	}
+	if reason := podcast.Episodes["episode-000"].History[1].Reason; !strings.HasPrefix(reason, "older than") {
+		t.Errorf("expected the episode to be removed for its age, got %q", reason)
+	}
+	if FileExists(filepath.Join(dir, "age", podcast.Episodes["episode-000"].Filename)) {
+		t.Errorf("expected the aged file to be removed")
+	}
+
+	// the global budget, counting the downloads of every podcast, and the free space stop downloads
+	config.Podcasts[0].MaxAge = 0
+	config.Podcasts[0].CountToKeep = 5
+	config.MaxBytes = feed.ByteSize(6*len(testAsset) + 1)
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	podcast, config = RunSync(t, fn, &backend, "age", "age")
+	if podcast.GetDownloadedCount() != 4 {
+		t.Errorf("expected the global budget to stop after 1 more download, got %d", podcast.GetDownloadedCount())
+	}
+	config.MaxBytes = 0
+	config.MinFreeSpace = feed.ByteSize(1 << 62)
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	podcast, _ = RunSync(t, fn, &backend, "age", "age")
+	if podcast.GetDownloadedCount() != 4 {
+		t.Errorf("expected no downloads without free space, got %d", podcast.GetDownloadedCount())
+	}
+}
+
+func TestSyncReconcile(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(syncCmd)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	original := GetRSS(ts.URL, t)
+	rss := original
+	mux.HandleFunc("/rss", func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(rss))
+	})
+	mux.HandleFunc("/asset/episode-000-v2.mp3", func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "audio/mpeg")
+		res.Write([]byte(testAsset + "-v2"))
+	})
+	mux.HandleFunc("/", func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "audio/mpeg")
+		res.Write([]byte(testAsset))
+	})
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheDirectory = ""
+	config.QuarantineDirectory = filepath.Join(dir, "quarantine")
+	config.Podcasts = append(config.Podcasts, &feed.Podcast{
+		Label:         "reconcile",
+		Feed:          ts.URL + "/rss",
+		Directory:     dir,
+		CountToKeep:   1,
+		Start:         "oldest",
+		ReplacedAudio: feed.ReplacedAudioRedownload,
+		Episodes:      make(map[string]*feed.Episode, 0),
+	})
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	RunSync(t, fn, &backend, "reconcile")
+
+	// episode-050 vanishes, episode-000's audio is replaced and episode-001 renamed
+	rss = regexp.MustCompile(`(?s)<item>\s*<title>episode-050.*?</item>`).ReplaceAllString(original, "")
+	rss = strings.ReplaceAll(rss, "/asset/episode-000.mp3", "/asset/episode-000-v2.mp3")
+	rss = strings.ReplaceAll(rss, "episode-001 this is episode #1<", "renamed episode 1<")
+	podcast, _ := RunSync(t, fn, &backend, "reconcile")
+	if podcast.Episodes["episode-050"].State != feed.Expired {
+		t.Errorf("expected the vanished episode to expire, got %v", podcast.Episodes["episode-050"].State)
+	}
+	replaced := podcast.Episodes["episode-000"]
+	contents, err := os.ReadFile(filepath.Join(dir, replaced.Filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced.State != feed.Downloaded || replaced.Replaced || string(contents) != testAsset+"-v2" || !strings.HasSuffix(replaced.URL, "-v2.mp3") {
+		t.Errorf("expected the replaced audio to be downloaded again, got %v %v %q", replaced.State, replaced.Replaced, contents)
+	}
+	renamed := podcast.Episodes["episode-001"]
+	if renamed.Title != "renamed episode 1" || !strings.Contains(renamed.Filename, "renamed-episode-1") {
+		t.Errorf("expected the new title and filename, got %q and %q", renamed.Title, renamed.Filename)
+	}
+
+	// the episode is back
+	rss = original
+	podcast, _ = RunSync(t, fn, &backend, "reconcile")
+	if podcast.Episodes["episode-050"].State != feed.New {
+		t.Errorf("expected the episode back in the feed to be new, got %v", podcast.Episodes["episode-050"].State)
+	}
+
+	// dynamically inserted ads change the length a little, that is not new audio
+	withLength := func(length int) string {
+		return strings.Replace(original, `episode-000.mp3" length="0"`, fmt.Sprintf(`episode-000.mp3" length="%d"`, length), 1)
+	}
+	rss = withLength(1000)
+	RunSync(t, fn, &backend, "reconcile")
+	rss = withLength(1100)
+	podcast, _ = RunSync(t, fn, &backend, "reconcile")
+	if podcast.Episodes["episode-000"].Replaced || podcast.Episodes["episode-000"].Attempts != 0 {
+		t.Errorf("expected a small change of length to keep the audio")
+	}
+
+	// a large change is new audio, which fails validation as it is much shorter than advertised
+	rss = withLength(100000)
+	podcast, _ = RunSync(t, fn, &backend, "reconcile")
+	replaced = podcast.Episodes["episode-000"]
+	contents, err = os.ReadFile(filepath.Join(dir, replaced.Filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced.State != feed.Downloaded || !replaced.Replaced || replaced.Attempts != 1 || string(contents) != testAsset {
+		t.Errorf("expected the rejected audio to be retried later and the file kept, got %v %v %d %q",
+			replaced.State, replaced.Replaced, replaced.Attempts, contents)
+	}
+	partial, err := filepath.Glob(filepath.Join(dir, "*.part"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	quarantined, err := filepath.Glob(filepath.Join(dir, "quarantine", "reconcile", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(partial) != 0 || len(quarantined) != 1 {
+		t.Errorf("expected the rejected download to be quarantined, got %v and %v", partial, quarantined)
+	}
+	// it is not tried again before the backoff
+	podcast, _ = RunSync(t, fn, &backend, "reconcile")
+	if podcast.Episodes["episode-000"].Attempts != 1 {
+		t.Errorf("expected the backoff to be respected, got %d attempts", podcast.Episodes["episode-000"].Attempts)
+	}
+}
+
+func TestSyncDates(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(syncCmd)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	item := func(guid, date string) string {
+		return fmt.Sprintf(`<item><title>%s</title><guid>%s</guid>%s<enclosure url="%s/asset/%s.mp3" length="0" type="audio/mpeg"/></item>`,
+			guid, guid, date, ts.URL, guid)
+	}
+	undated := ""
+	feedText := func() string {
+		return `<?xml version="1.0" encoding="UTF-8"?><rss version="2.0"><channel><title>dates</title>` +
+			item("named", "<pubDate>Wed, 15 Jan 2020 10:00:00 GMT</pubDate>") +
+			item("rfc3339", "<pubDate>2020-01-14T22:30:00-05:00</pubDate>") +
+			item("undated", undated) +
+			item("numeric", "<pubDate>Sun, 12 Jan 2020 09:00:00 +0000</pubDate>") +
+			`</channel></rss>`
+	}
+	mux.HandleFunc("/rss", func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(feedText()))
+	})
+	mux.HandleFunc("/", func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "audio/mpeg")
+		res.Write([]byte(testAsset))
+	})
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheDirectory = ""
+	config.TimeZone = "America/Chicago"
+	config.FilenameTemplate = `{{.episode.Date.Format "2006-01-02-15-04" }}-{{.episode.Title}}.mp3`
+	config.Podcasts = append(config.Podcasts, &feed.Podcast{
+		Label:       "dates",
+		Feed:        ts.URL + "/rss",
+		Directory:   dir,
+		CountToKeep: 1,
+		Start:       "newest",
+		Episodes:    make(map[string]*feed.Episode, 0),
+	})
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	podcast, _ := RunSync(t, fn, &backend, "dates")
+
+	expected := map[string]string{
+		"named":   "2020-01-15-04-00-named.mp3",
+		"rfc3339": "2020-01-14-21-30-rfc3339.mp3",
+		"undated": "2020-01-14-21-29-undated.mp3",
+		"numeric": "2020-01-12-03-00-numeric.mp3",
+	}
+	for guid, filename := range expected {
+		if podcast.Episodes[guid].Filename != filename {
+			t.Errorf("expected %s to be named %s, got %s", guid, filename, podcast.Episodes[guid].Filename)
+		}
+	}
+	if !podcast.Episodes["undated"].DateInferred || podcast.Episodes["rfc3339"].DateInferred {
+		t.Errorf("expected only the undated episode to have an inferred date")
+	}
+	if podcast.Episodes["named"].State != feed.Downloaded {
+		t.Errorf("expected the newest episode to be downloaded, got %v", podcast.Episodes["named"].State)
+	}
+
+	// the feed now dates the episode
+	undated = "<pubDate>Mon, 13 Jan 2020 12:00:00 +0000</pubDate>"
+	podcast, _ = RunSync(t, fn, &backend, "dates")
+	episode := podcast.Episodes["undated"]
+	if episode.DateInferred || episode.Filename != "2020-01-13-06-00-undated.mp3" {
+		t.Errorf("expected the feed's date to replace the inferred one, got %v %s", episode.DateInferred, episode.Filename)
+	}
+}
+
+func TestSyncEnclosurePreference(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(syncCmd)
+	defer ResetFlags(editCmd)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	rss := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:podcast="https://podcastindex.org/namespace/1.0"><channel><title>enclosures</title>
+<item><title>choice</title><guid>choice</guid><pubDate>Wed, 15 Jan 2020 10:00:00 GMT</pubDate>
+  <enclosure url="` + ts.URL + `/asset/choice.mp4" length="1000" type="video/mp4"/>
+  <podcast:alternateEnclosure type="audio/mpeg" length="64" bitrate="64000">
+    <podcast:source uri="ipfs://QmdwGqd3d2gFPGeJNLLCshdiPert45fMu84552Y4XHTy4y"/>
+    <podcast:source uri="` + ts.URL + `/asset/choice-64.mp3"/>
+  </podcast:alternateEnclosure>
+  <podcast:alternateEnclosure type="audio/mpeg" length="128" bitrate="128000">
+    <podcast:source uri="` + ts.URL + `/asset/choice-128.mp3"/>
+  </podcast:alternateEnclosure>
+</item>
+<item><title>video</title><guid>video</guid><pubDate>Tue, 14 Jan 2020 10:00:00 GMT</pubDate>
+  <enclosure url="` + ts.URL + `/asset/video.mp4" length="1000" type="video/mp4"/>
+</item>
+<item><title>plain</title><guid>plain</guid><pubDate>Mon, 13 Jan 2020 10:00:00 GMT</pubDate>
+  <enclosure url="` + ts.URL + `/asset/plain.m4a" length="10" type="audio/mp4"/>
+</item>
+</channel></rss>`
+	mux.HandleFunc("/rss", func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(rss))
+	})
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheDirectory = ""
+	config.Podcasts = append(config.Podcasts, &feed.Podcast{
+		Label:               "enclosures",
+		Feed:                ts.URL + "/rss",
+		Directory:           dir,
+		Mode:                feed.ModeManual,
+		EnclosurePreference: []string{"audio/mpeg bitrate<=64k", "audio/mpeg", "!video/*"},
+		Episodes:            make(map[string]*feed.Episode, 0),
+	})
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	podcast, _ := RunSync(t, fn, &backend, "enclosures")
+
+	choice := podcast.Episodes["choice"]
+	if !strings.HasSuffix(choice.URL, "/asset/choice-64.mp3") || choice.Type != "audio/mpeg" || choice.Length != 64 {
+		t.Errorf("expected the 64k alternate enclosure, got %s %s %d", choice.URL, choice.Type, choice.Length)
+	}
+	if podcast.Episodes["video"] != nil {
+		t.Errorf("expected the video only episode to be excluded")
+	}
+	if plain := podcast.Episodes["plain"]; plain == nil || plain.Type != "audio/mp4" {
+		t.Errorf("expected the episode with no preferred enclosure to use its own, got %v", plain)
+	}
+
+	RunCommand(t, fn, "edit", "enclosures", "--enclosure", "audio/mpeg bitrate>=100k")
+	podcast, _ = LoadPodcast(t, &backend, "enclosures")
+	if len(podcast.EnclosurePreference) != 1 || podcast.EnclosurePreference[0] != "audio/mpeg bitrate>=100k" {
+		t.Fatalf("expected the edited enclosure preference, got %v", podcast.EnclosurePreference)
+	}
+	podcast, _ = RunSync(t, fn, &backend, "enclosures")
+	if choice := podcast.Episodes["choice"]; !strings.HasSuffix(choice.URL, "/asset/choice-128.mp3") {
+		t.Errorf("expected the 128k alternate enclosure, got %s", choice.URL)
+	}
+	if video := podcast.Episodes["video"]; video == nil || video.Type != "video/mp4" {
+		t.Errorf("expected the video episode once video is no longer excluded, got %v", video)
+	}
+}
+
+func TestSyncMetadata(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(syncCmd)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	rss := `<?xml version="1.0" encoding="UTF-8"?>
+<rss version="2.0" xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd"><channel><title>metadata</title>
+<item><title>the pilot</title><guid>pilot</guid><pubDate>Wed, 15 Jan 2020 10:00:00 GMT</pubDate>
+  <description>the first episode</description><link>https://example.com/pilot</link>
+  <itunes:duration>1:02:03</itunes:duration><itunes:episode>1</itunes:episode><itunes:season>2</itunes:season>
+  <itunes:episodeType>Full</itunes:episodeType><itunes:explicit>yes</itunes:explicit>
+  <itunes:image href="https://example.com/pilot.jpg"/>
+  <enclosure url="` + ts.URL + `/asset/pilot.mp3" length="16" type="audio/mpeg"/>
+</item>
+<item><title>the trailer</title><guid>trailer</guid><pubDate>Tue, 14 Jan 2020 10:00:00 GMT</pubDate>
+  <itunes:summary>coming soon</itunes:summary><itunes:duration>90</itunes:duration>
+  <itunes:episodeType>trailer</itunes:episodeType><itunes:explicit>false</itunes:explicit>
+  <enclosure url="` + ts.URL + `/asset/trailer.mp3" length="16" type="audio/mpeg"/>
+</item>
+</channel></rss>`
+	mux.HandleFunc("/rss", func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(rss))
+	})
+	mux.HandleFunc("/", func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "audio/mpeg")
+		res.Write([]byte(testAsset))
+	})
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheDirectory = ""
+	config.ExtendedPlaylist = true
+	config.FilenameTemplate = `S{{.episode.Season}}E{{.episode.Number}}-{{.episode.Title}}.mp3`
+	config.Podcasts = append(config.Podcasts, &feed.Podcast{
+		Label:       "metadata",
+		Feed:        ts.URL + "/rss",
+		Directory:   dir,
+		CountToKeep: 2,
+		Start:       "oldest",
+		Episodes:    make(map[string]*feed.Episode, 0),
+	})
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	podcast, _ := RunSync(t, fn, &backend, "metadata")
+
+	pilot := podcast.Episodes["pilot"]
+	expected := feed.Episode{
+		Description: "the first episode",
+		Link:        "https://example.com/pilot",
+		Image:       "https://example.com/pilot.jpg",
+		Duration:    time.Hour + 2*time.Minute + 3*time.Second,
+		Number:      1,
+		Season:      2,
+		EpisodeType: "full",
+		Explicit:    true,
+		Type:        "audio/mpeg",
+		Length:      16,
+		Filename:    "S2E1-the-pilot.mp3",
+	}
+	actual := feed.Episode{
+		Description: pilot.Description,
+		Link:        pilot.Link,
+		Image:       pilot.Image,
+		Duration:    pilot.Duration,
+		Number:      pilot.Number,
+		Season:      pilot.Season,
+		EpisodeType: pilot.EpisodeType,
+		Explicit:    pilot.Explicit,
+		Type:        pilot.Type,
+		Length:      pilot.Length,
+		Filename:    pilot.Filename,
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("unexpected metadata\nexpected: %+v\nactual:   %+v", expected, actual)
+	}
+	trailer := podcast.Episodes["trailer"]
+	if trailer.Description != "coming soon" || trailer.Duration != 90*time.Second || trailer.EpisodeType != "trailer" || trailer.Explicit {
+		t.Errorf("unexpected trailer metadata %+v", trailer)
+	}
+
+	playlist, err := os.ReadFile(filepath.Join(dir, podcast.PlaylistFilename()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedPlaylist := "#EXTM3U\n" +
+		"#EXTINF:90,metadata - the trailer\n" + trailer.Filename + "\n" +
+		"#EXTINF:3723,metadata - the pilot\n" + pilot.Filename + "\n"
+	if string(playlist) != expectedPlaylist {
+		t.Errorf("unexpected playlist\nexpected:\n%s\nactual:\n%s", expectedPlaylist, playlist)
+	}
+	if details := podcast.PrintDetails(); !strings.Contains(details, "Duration on disk: 1h4m0s") {
+		t.Errorf("expected the duration on disk, got\n%s", details)
+	}
+}
+
+func TestSyncFeedMoves(t *testing.T) {
+	fn, _ := CreateTestConfigFile(t)
+	defer os.Remove(fn)
+	defer ResetFlags(syncCmd)
+	defer ResetFlags(editCmd)
+	dir, err := os.MkdirTemp("", "test_padcast_feed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	mux := http.NewServeMux()
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	mux.HandleFunc("/new", func(res http.ResponseWriter, req *http.Request) {
+		res.Write([]byte(GetRSS(ts.URL, t)))
+	})
+	mux.Handle("/old", http.RedirectHandler("/new", http.StatusMovedPermanently))
+	mux.Handle("/old-308", http.RedirectHandler("/new", http.StatusPermanentRedirect))
+	// a temporary redirect is not a move
+	mux.Handle("/temporary", http.RedirectHandler("/new", http.StatusFound))
+	mux.HandleFunc("/declared", func(res http.ResponseWriter, req *http.Request) {
+		rss := strings.Replace(GetRSS(ts.URL, t), "<rss ", `<rss xmlns:itunes="http://www.itunes.com/dtds/podcast-1.0.dtd" `, 1)
+		rss = strings.Replace(rss, "<channel>", "<channel><itunes:new-feed-url>"+ts.URL+"/new</itunes:new-feed-url>", 1)
+		res.Write([]byte(rss))
+	})
+
+	backend := feed.FileBackend{}
+	backend.Init(fn)
+	config, err := backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CacheDirectory = ""
+	for _, label := range []string{"old", "declared", "temporary"} {
+		config.Podcasts = append(config.Podcasts, &feed.Podcast{
+			Label:     label,
+			Feed:      ts.URL + "/" + label,
+			Directory: filepath.Join(dir, label),
+			Mode:      feed.ModeManual,
+			Episodes:  make(map[string]*feed.Episode, 0),
+		})
+	}
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := RunCommand(t, fn, "sync")
+	for _, label := range []string{"old", "declared"} {
+		moved, _ := LoadPodcast(t, &backend, label)
+		if moved.Feed != ts.URL+"/new" || len(moved.FeedHistory) != 1 || moved.FeedHistory[0].From != ts.URL+"/"+label {
+			t.Errorf("%s: expected the feed to move to /new, got %s %+v", label, moved.Feed, moved.FeedHistory)
+		}
+		if !strings.Contains(output, fmt.Sprintf("%s: the feed moved from %s/%s to %s/new", label, ts.URL, label, ts.URL)) {
+			t.Errorf("%s: expected the move in the summary, got %q", label, output)
+		}
+	}
+	if temporary, _ := LoadPodcast(t, &backend, "temporary"); temporary.Feed != ts.URL+"/temporary" || len(temporary.FeedHistory) != 0 {
+		t.Errorf("expected a temporary redirect to keep the feed, got %s", temporary.Feed)
+	}
+	if moved, _ := LoadPodcast(t, &backend, "old"); len(moved.Episodes) != 100 {
+		t.Errorf("expected the moved feed to be synced, got %d episodes", len(moved.Episodes))
+	}
+
+	// with confirmation the move waits for edit --accept-feed-move
+	config, err = backend.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.ConfirmFeedMoves = true
+	config.Podcasts = append(config.Podcasts, &feed.Podcast{
+		Label:     "confirm",
+		Feed:      ts.URL + "/old-308",
+		Directory: filepath.Join(dir, "confirm"),
+		Mode:      feed.ModeManual,
+		Episodes:  make(map[string]*feed.Episode, 0),
+	})
+	err = backend.Save(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output = RunCommand(t, fn, "sync", "confirm")
+	confirm, _ := LoadPodcast(t, &backend, "confirm")
+	if confirm.Feed != ts.URL+"/old-308" || confirm.PendingFeed != ts.URL+"/new" {
+		t.Errorf("expected the move to wait for confirmation, got %s and %s", confirm.Feed, confirm.PendingFeed)
+	}
+	if !strings.Contains(output, "castigate edit confirm --accept-feed-move") {
+		t.Errorf("expected the pending move in the summary, got %q", output)
+	}
+	RunCommand(t, fn, "edit", "confirm", "--accept-feed-move")
+	confirm, _ = LoadPodcast(t, &backend, "confirm")
+	if confirm.Feed != ts.URL+"/new" || confirm.PendingFeed != "" || len(confirm.FeedHistory) != 1 {
+		t.Errorf("expected the accepted move, got %s %q %+v", confirm.Feed, confirm.PendingFeed, confirm.FeedHistory)
+	}
+}
@@ -2,19 +2,27 @@ package feed
 
 import (
 	"database/sql"
+	"errors"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 	"os"
+	"sync"
 )
 
 type BackendInterface interface {
 	Init(filename string)
 	Load() (Config, error)
 	Save(Config) error
+	// CheckpointPodcast persists the state of one podcast without saving the whole config
+	CheckpointPodcast(podcast *Podcast) error
+	// CheckpointEpisode persists the state of one episode, stored under key in the podcast
+	CheckpointEpisode(podcast *Podcast, key string, episode *Episode) error
 }
 
 type FileBackend struct {
 	Filename string
+	// mutex serializes writes to the journal
+	mutex sync.Mutex
 }
 type SqliteBackend struct {
 	Database *sql.DB
@@ -115,6 +123,52 @@ func (b *SqliteBackend) Save(config Config) error {
 	return transaction.Commit()
 }
 
+func (b *SqliteBackend) CheckpointPodcast(podcast *Podcast) error {
+	transaction, err := b.Database.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = transaction.Exec("update podcast set Feed = ?, Directory = ?, CountToKeep = ?, Start = ? where Label = ?",
+		podcast.Feed, podcast.Directory, podcast.CountToKeep, podcast.Start, podcast.Label)
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+	_, err = transaction.Exec("delete from episode where PodcastLabel = ?", podcast.Label)
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+	for _, episode := range podcast.Episodes {
+		_, err = transaction.Exec("insert into episode ( GUID, URL, State, Filename, Date, PodcastLabel ) values ( ?, ?, ?, ?, ?, ? )",
+			episode.GUID, episode.URL, episode.State, episode.Filename, episode.Date, podcast.Label)
+		if err != nil {
+			transaction.Rollback()
+			return err
+		}
+	}
+	return transaction.Commit()
+}
+
+func (b *SqliteBackend) CheckpointEpisode(podcast *Podcast, key string, episode *Episode) error {
+	transaction, err := b.Database.Begin()
+	if err != nil {
+		return err
+	}
+	_, err = transaction.Exec("delete from episode where PodcastLabel = ? and GUID = ?", podcast.Label, episode.GUID)
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+	_, err = transaction.Exec("insert into episode ( GUID, URL, State, Filename, Date, PodcastLabel ) values ( ?, ?, ?, ?, ?, ? )",
+		episode.GUID, episode.URL, episode.State, episode.Filename, episode.Date, podcast.Label)
+	if err != nil {
+		transaction.Rollback()
+		return err
+	}
+	return transaction.Commit()
+}
+
 func (b *FileBackend) Init(filename string) {
 	b.Filename = filename
 }
@@ -128,9 +182,19 @@ func (b *FileBackend) Load() (Config, error) {
 		return Config{}, err
 	}
 	config := Config{
-		Podcasts:           make([]*Podcast, 0),
-		FilenameTemplate:   `{{.episode.Date.Format "2006-01-02-15:04:05" }}-{{.item.Title}}.mp3`,
-		DefaultCountToKeep: 10,
+		Podcasts:            make([]*Podcast, 0),
+		FilenameTemplate:    `{{.episode.Date.Format "2006-01-02-15:04:05" }}-{{.item.Title}}.mp3`,
+		DefaultCountToKeep:  10,
+		QuarantineDirectory: DefaultQuarantineDirectory,
+		CacheDirectory:      DefaultCacheDirectory,
+		MaxAttempts:         DefaultMaxAttempts,
+		RetryBackoff:        DefaultRetryBackoff,
+		ArchiveDelay:        DefaultArchiveDelay,
+		ArchiveBatchSize:    DefaultArchiveBatchSize,
+		HTTP: HTTPConfig{
+			ConnectTimeout: DefaultConnectTimeout,
+			ReadTimeout:    DefaultReadTimeout,
+		},
 	}
 
 	err = yaml.Unmarshal(contents, &config)
@@ -138,6 +202,11 @@ func (b *FileBackend) Load() (Config, error) {
 		log.Errorf("failed to load YAML: %s", err)
 		return Config{}, err
 	}
+	err = b.replayJournal(&config)
+	if err != nil {
+		log.Errorf("failed to replay journal: %s", err)
+		return Config{}, err
+	}
 	return config, nil
 }
 
@@ -154,11 +223,18 @@ func (b *FileBackend) Save(config Config) error {
 		return err
 	}
 
-	// Write the new config
-	err = os.WriteFile(b.Filename, buffer, 0644)
+	// Write the new config, replacing the old one only once it is complete
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	err = writeFileAtomic(b.Filename, buffer)
 	if err != nil {
 		log.Errorf("failed to save YAML: %s", err)
 		return err
 	}
+	// the config now holds everything in the journal
+	err = os.Remove(b.journalFilename())
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
 	return err
 }
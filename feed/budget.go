@@ -0,0 +1,91 @@
+package feed
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"sync"
+)
+
+// DiskBudget holds the bytes used by the downloads of every podcast against the
+// config's MaxBytes and MinFreeSpace.  A single DiskBudget is shared by every
+// podcast in a sync.  A nil DiskBudget allows everything.
+type DiskBudget struct {
+	mutex        sync.Mutex
+	used         int64
+	maxBytes     int64
+	minFreeSpace int64
+}
+
+// NewDiskBudget creates a budget from the config with the current disk usage of
+// every podcast, or returns nil if neither MaxBytes nor MinFreeSpace is set.
+func NewDiskBudget(config Config, configFilePath string) *DiskBudget {
+	if config.MaxBytes <= 0 && config.MinFreeSpace <= 0 {
+		return nil
+	}
+	budget := &DiskBudget{maxBytes: int64(config.MaxBytes), minFreeSpace: int64(config.MinFreeSpace)}
+	for _, podcast := range config.Podcasts {
+		budget.used += podcast.DiskUsage(configFilePath)
+	}
+	return budget
+}
+
+// Reserve takes size bytes of the budget for a download into directory, or returns
+// an error if the download would exceed MaxBytes or leave less than MinFreeSpace.
+func (b *DiskBudget) Reserve(directory string, size int64) error {
+	if b == nil {
+		return nil
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.maxBytes > 0 && b.used+size > b.maxBytes {
+		return fmt.Errorf("the downloads use %s of the %s budget", ByteSize(b.used), ByteSize(b.maxBytes))
+	}
+	if b.minFreeSpace > 0 {
+		os.MkdirAll(directory, 0755)
+		free, err := freeSpace(directory)
+		if err != nil {
+			log.Debugf("could not check the free space of %s: %v", directory, err)
+		} else if free-size < b.minFreeSpace {
+			return fmt.Errorf("%s free in %s, the minimum is %s", ByteSize(free), directory, ByteSize(b.minFreeSpace))
+		}
+	}
+	b.used += size
+	return nil
+}
+
+// Adjust changes the bytes used, for instance by the difference between a reserved
+// and the downloaded size, or to release the space of a removed file.
+func (b *DiskBudget) Adjust(delta int64) {
+	if b == nil {
+		return
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.used += delta
+}
+
+// DiskUsage is the size of the podcast's episode files in its directory.
+func (podcast *Podcast) DiskUsage(configFilePath string) int64 {
+	return podcast.diskUsage(podcast.ResolveDirectory(configFilePath))
+}
+
+func (podcast *Podcast) diskUsage(directory string) int64 {
+	var used int64
+	for _, episode := range podcast.Episodes {
+		if episode.OnDisk() {
+			used += fileSize(path.Join(directory, episode.Filename))
+		}
+	}
+	return used
+}
+
+// fileSize returns the size of the file, or 0 if it does not exist.
+func fileSize(filename string) int64 {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
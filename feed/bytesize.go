@@ -0,0 +1,68 @@
+package feed
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"strconv"
+	"strings"
+)
+
+// ByteSize is a number of bytes, written in the config as a plain number or with
+// a unit, for instance "500KB", "1.5MB" or "2GiB".  KB, MB, GB and TB are powers
+// of 1000, KiB, MiB, GiB and TiB powers of 1024.
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   float64
+}{
+	{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10},
+	{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3},
+	{"T", 1e12}, {"G", 1e9}, {"M", 1e6}, {"K", 1e3},
+	{"B", 1},
+}
+
+// ParseByteSize parses a size such as "1500", "500KB" or "1.5GiB".  Units are not case sensitive.
+func ParseByteSize(s string) (ByteSize, error) {
+	s = strings.TrimSpace(s)
+	for _, unit := range byteUnits {
+		if len(s) > len(unit.suffix) && strings.EqualFold(s[len(s)-len(unit.suffix):], unit.suffix) {
+			number, err := strconv.ParseFloat(strings.TrimSpace(s[:len(s)-len(unit.suffix)]), 64)
+			if err != nil || number < 0 {
+				return 0, fmt.Errorf("invalid size %q", s)
+			}
+			return ByteSize(number * unit.size), nil
+		}
+	}
+	number, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return ByteSize(number), nil
+}
+
+func (b ByteSize) String() string {
+	for _, unit := range byteUnits {
+		size := int64(unit.size)
+		if size > 1 && b != 0 && int64(b)%size == 0 {
+			return fmt.Sprintf("%d%s", int64(b)/size, unit.suffix)
+		}
+	}
+	return strconv.FormatInt(int64(b), 10)
+}
+
+func (b *ByteSize) UnmarshalYAML(value *yaml.Node) error {
+	size, err := ParseByteSize(value.Value)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+func (b ByteSize) MarshalYAML() (interface{}, error) {
+	if b == 0 {
+		return 0, nil
+	}
+	return b.String(), nil
+}
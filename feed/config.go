@@ -2,21 +2,97 @@ package feed
 
 import (
 	"fmt"
+	"time"
 )
 
 const DefaultFilenameTemplate = `{{.episode.Date.Format "2006-01-02-15-04-05" }}-{{.episode.Title}}.mp3`
 
+// DefaultCacheDirectory keeps the last copy of each feed, relative to the config file
+const DefaultCacheDirectory = "cache"
+
+// DefaultQuarantineDirectory holds downloads that failed validation, relative to the config file
+const DefaultQuarantineDirectory = "quarantine"
+
+// DefaultMaxAttempts is the number of syncs that try an episode before it is marked Failed
+const DefaultMaxAttempts = 5
+
+// DefaultArchiveDelay is the pause between downloads of podcasts in archive mode
+const DefaultArchiveDelay = 2 * time.Second
+
+// DefaultArchiveBatchSize is the number of episodes of an archived podcast downloaded by each sync
+const DefaultArchiveBatchSize = 25
+
+// DefaultRetryBackoff is the wait before a failed episode is tried again, doubled after each attempt
+const DefaultRetryBackoff = time.Hour
+
 type Config struct {
 	Podcasts           []*Podcast
 	FilenameTemplate   string
 	DefaultCountToKeep int
+	// QuarantineDirectory holds downloads that failed validation, one subdirectory per podcast
+	QuarantineDirectory string
+	// TrashDirectory receives the files removed by pruning, one subdirectory per podcast,
+	// pruned files are deleted if empty
+	TrashDirectory string
+	// CacheDirectory keeps the last copy of each feed, caching is disabled if empty
+	CacheDirectory string
+	// HTTP configures the client for feeds and downloads, podcasts may override it
+	HTTP HTTPConfig `yaml:"http"`
+	// RateLimit is the total download bandwidth in bytes per second, 0 for no limit
+	RateLimit ByteSize
+	// DownloadWindows are the times of day, "01:00-06:00", in which episodes are downloaded
+	DownloadWindows []string
+	// MaxAttempts is the number of syncs that try an episode before it is marked Failed, 0 for no limit
+	MaxAttempts int
+	// RetryBackoff is the wait before a failed episode is tried again, doubled after each attempt
+	RetryBackoff time.Duration
+	// ConfirmFeedMoves waits for "castigate edit --accept-feed-move" before following a moved feed
+	ConfirmFeedMoves bool
+	// ExtendedPlaylist writes #EXTINF lines with the duration and title of each episode to the playlists
+	ExtendedPlaylist bool
+	// TimeZone is the IANA time zone, for instance America/Chicago, of dates in
+	// filenames, empty to keep the zone of each feed's dates
+	TimeZone string
+	// MaxAge removes downloaded episodes published longer ago and skips downloading them, 0 for no limit
+	MaxAge time.Duration
+	// MaxBytes is the total size of the downloads of every podcast, 0 for no limit
+	MaxBytes ByteSize
+	// MinFreeSpace stops downloads when the free disk space would drop below it, 0 for no limit
+	MinFreeSpace ByteSize
+	// ArchiveDelay is the pause between downloads of podcasts in archive mode
+	ArchiveDelay time.Duration
+	// ArchiveBatchSize is the number of episodes of an archived podcast downloaded by each sync, 0 for no limit
+	ArchiveBatchSize int
+
+	// Limiter is shared by all podcasts during a sync to bound concurrent downloads
+	Limiter *Limiter `yaml:"-"`
+	// RateLimiter applies RateLimit across all podcasts during a sync
+	RateLimiter *RateLimiter `yaml:"-"`
+	// DiskBudget applies MaxBytes and MinFreeSpace across all podcasts during a sync
+	DiskBudget *DiskBudget `yaml:"-"`
+	// Offline syncs from the cached feeds without using the network
+	Offline bool `yaml:"-"`
+	// DryRun fetches feeds without writing anything to disk
+	DryRun bool `yaml:"-"`
+	// EpisodeCheckpoint, if set, is called each time sync changes the state of an episode
+	EpisodeCheckpoint func(podcast *Podcast, episode *Episode) `yaml:"-"`
 }
 
 func NewConfig() Config {
 	return Config{
-		Podcasts:           nil,
-		FilenameTemplate:   DefaultFilenameTemplate,
-		DefaultCountToKeep: 10,
+		Podcasts:            nil,
+		FilenameTemplate:    DefaultFilenameTemplate,
+		DefaultCountToKeep:  10,
+		QuarantineDirectory: DefaultQuarantineDirectory,
+		CacheDirectory:      DefaultCacheDirectory,
+		MaxAttempts:         DefaultMaxAttempts,
+		RetryBackoff:        DefaultRetryBackoff,
+		ArchiveDelay:        DefaultArchiveDelay,
+		ArchiveBatchSize:    DefaultArchiveBatchSize,
+		HTTP: HTTPConfig{
+			ConnectTimeout: DefaultConnectTimeout,
+			ReadTimeout:    DefaultReadTimeout,
+		},
 	}
 }
 func (c Config) FindPodcast(label string) (*Podcast, error) {
@@ -27,3 +103,33 @@ func (c Config) FindPodcast(label string) (*Podcast, error) {
 	}
 	return nil, fmt.Errorf("podcast not found")
 }
+
+// SelectPodcasts returns the podcasts with one of the labels or tags, in config order.
+// If no labels or tags are given every podcast is returned.  Unknown labels are an error.
+func (c Config) SelectPodcasts(labels []string, tags []string) ([]*Podcast, error) {
+	if len(labels) == 0 && len(tags) == 0 {
+		return c.Podcasts, nil
+	}
+	selected := make(map[*Podcast]bool)
+	for _, label := range labels {
+		podcast, err := c.FindPodcast(label)
+		if err != nil {
+			return nil, fmt.Errorf("podcast %s not found", label)
+		}
+		selected[podcast] = true
+	}
+	for _, podcast := range c.Podcasts {
+		for _, tag := range tags {
+			if podcast.HasTag(tag) {
+				selected[podcast] = true
+			}
+		}
+	}
+	podcasts := make([]*Podcast, 0, len(selected))
+	for _, podcast := range c.Podcasts {
+		if selected[podcast] {
+			podcasts = append(podcasts, podcast)
+		}
+	}
+	return podcasts, nil
+}
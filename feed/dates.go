@@ -0,0 +1,132 @@
+package feed
+
+import (
+	"github.com/mmcdole/gofeed"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+)
+
+// dateLayouts are tried, in order, on dates gofeed could not parse.
+var dateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	time.RFC822Z,
+	time.RFC822,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2006-01-02 15:04:05",
+	time.DateOnly,
+}
+
+// parseDate parses a date in one of dateLayouts.
+func parseDate(value string) (time.Time, bool) {
+	value = strings.TrimSpace(value)
+	for _, layout := range dateLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// itemDate returns the date of the item from its published or updated date, and
+// false if the item has no usable date.
+func itemDate(item *gofeed.Item) (time.Time, bool) {
+	if item.PublishedParsed != nil && !item.PublishedParsed.IsZero() {
+		return *item.PublishedParsed, true
+	}
+	if item.UpdatedParsed != nil && !item.UpdatedParsed.IsZero() {
+		return *item.UpdatedParsed, true
+	}
+	if t, ok := parseDate(item.Published); ok {
+		return t, true
+	}
+	return parseDate(item.Updated)
+}
+
+// feedDate is the time the feed was last changed, used to infer the dates of items
+// without one.  It is the feed's own date, or the HTTP Last-Modified, or now.
+func feedDate(feed *gofeed.Feed, header http.Header) time.Time {
+	if feed.UpdatedParsed != nil && !feed.UpdatedParsed.IsZero() {
+		return *feed.UpdatedParsed
+	}
+	if feed.PublishedParsed != nil && !feed.PublishedParsed.IsZero() {
+		return *feed.PublishedParsed
+	}
+	if header != nil {
+		if t, err := http.ParseTime(header.Get("Last-Modified")); err == nil {
+			return t
+		}
+	}
+	return time.Now()
+}
+
+// inferDates returns the date of each item of the feed.  Items without a usable
+// date are given one from their position in the feed: a minute before the next
+// newer item with a date, or before the feed's date if there is none.
+func (podcast *Podcast) inferDates(feed *gofeed.Feed, header http.Header) ([]time.Time, []bool) {
+	dates := make([]time.Time, len(feed.Items))
+	inferred := make([]bool, len(feed.Items))
+	var first, last time.Time
+	for index, item := range feed.Items {
+		date, ok := itemDate(item)
+		if !ok {
+			inferred[index] = true
+			continue
+		}
+		dates[index] = date
+		if first.IsZero() {
+			first = date
+		}
+		last = date
+	}
+	// feeds are usually newest first, walk from the newest item either way
+	order := make([]int, len(feed.Items))
+	for index := range order {
+		order[index] = index
+	}
+	if last.After(first) {
+		slices.Reverse(order)
+	}
+	newer := feedDate(feed, header)
+	for _, index := range order {
+		if inferred[index] {
+			item := feed.Items[index]
+			dates[index] = newer.Add(-time.Minute)
+			log.Warnf("%s: %q has no usable date (%q), using %s from its position in the feed",
+				podcast.Label, item.Title, item.Published, dates[index].Format(time.RFC1123Z))
+		}
+		newer = dates[index]
+	}
+	return dates, inferred
+}
+
+// Location returns the time zone of TimeZone, or nil to keep the zone of each
+// feed's dates.
+func (c Config) Location() *time.Location {
+	if c.TimeZone == "" {
+		return nil
+	}
+	location, err := time.LoadLocation(c.TimeZone)
+	if err != nil {
+		log.Errorf("unknown time zone %s, keeping the feed's time zones: %v", c.TimeZone, err)
+		return nil
+	}
+	return location
+}
+
+// episodeFilename formats the episode's filename with the episode's date in the
+// config's time zone.
+func (podcast *Podcast) episodeFilename(config Config, episode *Episode, item *gofeed.Item) string {
+	local := *episode
+	if location := config.Location(); location != nil {
+		local.Date = episode.Date.In(location)
+	}
+	return podcast.FormatFilename(config.FilenameTemplate, &local, item)
+}
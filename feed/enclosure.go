@@ -0,0 +1,241 @@
+package feed
+
+import (
+	"fmt"
+	"github.com/mmcdole/gofeed"
+	log "github.com/sirupsen/logrus"
+	"mime"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// DefaultEnclosurePreference prefers audio when a podcast has no preference.
+var DefaultEnclosurePreference = []string{"audio/*"}
+
+// enclosureSource is one way to download an episode, an enclosure or a
+// podcast:source of a podcast:alternateEnclosure.
+type enclosureSource struct {
+	URL     string
+	Type    string
+	Length  int64
+	Bitrate float64 // bits per second, 0 if unknown
+	Codecs  string
+}
+
+// enclosureCondition matches a source against one term of a preference.
+type enclosureCondition func(source enclosureSource) bool
+
+// enclosureRule matches a source if all its conditions do.
+type enclosureRule []enclosureCondition
+
+func (rule enclosureRule) matches(source enclosureSource) bool {
+	for _, condition := range rule {
+		if !condition(source) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseBitrate parses a bitrate in bits per second, with an optional k or M suffix.
+func parseBitrate(value string) (float64, error) {
+	multiplier := 1.0
+	switch {
+	case strings.HasSuffix(value, "k"):
+		multiplier = 1000
+		value = strings.TrimSuffix(value, "k")
+	case strings.HasSuffix(value, "M"):
+		multiplier = 1000 * 1000
+		value = strings.TrimSuffix(value, "M")
+	}
+	bitrate, err := strconv.ParseFloat(value, 64)
+	if err != nil || bitrate < 0 {
+		return 0, fmt.Errorf("invalid bitrate %q", value)
+	}
+	return bitrate * multiplier, nil
+}
+
+// parseEnclosureCondition parses a term such as "audio/mpeg", "video/*",
+// "codec=opus", "bitrate<=64k" or "host=cdn.example.com".
+func parseEnclosureCondition(term string) (enclosureCondition, error) {
+	switch {
+	case strings.HasPrefix(term, "codec="):
+		codec := strings.ToLower(strings.TrimPrefix(term, "codec="))
+		return func(source enclosureSource) bool {
+			for _, c := range strings.Split(strings.ToLower(source.Codecs), ",") {
+				c = strings.TrimSpace(c)
+				if c == codec || strings.HasPrefix(c, codec+".") {
+					return true
+				}
+			}
+			return false
+		}, nil
+	case strings.HasPrefix(term, "bitrate<="):
+		limit, err := parseBitrate(strings.TrimPrefix(term, "bitrate<="))
+		if err != nil {
+			return nil, err
+		}
+		return func(source enclosureSource) bool {
+			return source.Bitrate > 0 && source.Bitrate <= limit
+		}, nil
+	case strings.HasPrefix(term, "bitrate>="):
+		limit, err := parseBitrate(strings.TrimPrefix(term, "bitrate>="))
+		if err != nil {
+			return nil, err
+		}
+		return func(source enclosureSource) bool {
+			return source.Bitrate >= limit
+		}, nil
+	case strings.HasPrefix(term, "host="):
+		host := strings.ToLower(strings.TrimPrefix(term, "host="))
+		return func(source enclosureSource) bool {
+			u, err := url.Parse(source.URL)
+			if err != nil {
+				return false
+			}
+			hostname := strings.ToLower(u.Hostname())
+			return hostname == host || strings.HasSuffix(hostname, "."+host)
+		}, nil
+	case strings.Count(term, "/") == 1:
+		mediaType := strings.ToLower(term)
+		return func(source enclosureSource) bool {
+			sourceType := source.mediaType()
+			if strings.HasSuffix(mediaType, "/*") {
+				return strings.HasPrefix(sourceType, strings.TrimSuffix(mediaType, "*"))
+			}
+			return sourceType == mediaType
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown enclosure preference %q, expected a MIME type, codec=, bitrate<=, bitrate>= or host=", term)
+}
+
+// parseEnclosurePreference parses a preference list into the rules to rank sources
+// by, in order, and the rules excluding sources, the entries starting with "!".
+func parseEnclosurePreference(preference []string) ([]enclosureRule, []enclosureRule, error) {
+	var include, exclude []enclosureRule
+	for _, entry := range preference {
+		negate := strings.HasPrefix(entry, "!")
+		terms := strings.Fields(strings.TrimPrefix(entry, "!"))
+		if len(terms) == 0 {
+			return nil, nil, fmt.Errorf("empty enclosure preference %q", entry)
+		}
+		var rule enclosureRule
+		for _, term := range terms {
+			condition, err := parseEnclosureCondition(term)
+			if err != nil {
+				return nil, nil, err
+			}
+			rule = append(rule, condition)
+		}
+		if negate {
+			exclude = append(exclude, rule)
+		} else {
+			include = append(include, rule)
+		}
+	}
+	return include, exclude, nil
+}
+
+// ValidateEnclosurePreference returns an error if an entry of the preference list
+// can not be parsed.
+func ValidateEnclosurePreference(preference []string) error {
+	_, _, err := parseEnclosurePreference(preference)
+	return err
+}
+
+// mediaType is the source's MIME type without parameters, guessed from the URL's
+// extension if the feed did not give one.
+func (source enclosureSource) mediaType() string {
+	mediaType := source.Type
+	if mediaType == "" {
+		if u, err := url.Parse(source.URL); err == nil {
+			mediaType = mime.TypeByExtension(path.Ext(u.Path))
+		}
+	}
+	if parsed, _, err := mime.ParseMediaType(mediaType); err == nil {
+		return parsed
+	}
+	return strings.ToLower(mediaType)
+}
+
+// itemSources returns the item's enclosures followed by the HTTP sources of its
+// podcast:alternateEnclosure elements.
+func itemSources(item *gofeed.Item) []enclosureSource {
+	var sources []enclosureSource
+	for _, enclosure := range item.Enclosures {
+		length, _ := strconv.ParseInt(enclosure.Length, 10, 64)
+		sources = append(sources, enclosureSource{URL: enclosure.URL, Type: enclosure.Type, Length: length})
+	}
+	for _, alternate := range item.Extensions["podcast"]["alternateEnclosure"] {
+		length, _ := strconv.ParseInt(alternate.Attrs["length"], 10, 64)
+		bitrate, _ := strconv.ParseFloat(alternate.Attrs["bitrate"], 64)
+		for _, source := range alternate.Children["source"] {
+			uri := source.Attrs["uri"]
+			if !strings.HasPrefix(uri, "http://") && !strings.HasPrefix(uri, "https://") {
+				// torrents and IPFS can not be downloaded
+				continue
+			}
+			sources = append(sources, enclosureSource{
+				URL:     uri,
+				Type:    alternate.Attrs["type"],
+				Length:  length,
+				Bitrate: bitrate,
+				Codecs:  alternate.Attrs["codecs"],
+			})
+		}
+	}
+	return sources
+}
+
+// chooseEnclosure returns the item's source ranked first by the preference, the
+// first entry a source matches is its rank, sources matching no entry come last
+// and excluded sources are never chosen.  Sources of the same rank keep the
+// order of the feed.  It returns false if the item has no acceptable source.
+func chooseEnclosure(item *gofeed.Item, include []enclosureRule, exclude []enclosureRule) (enclosureSource, bool) {
+	var best enclosureSource
+	bestRank := -1
+	for _, source := range itemSources(item) {
+		if source.URL == "" {
+			continue
+		}
+		excluded := false
+		for _, rule := range exclude {
+			if rule.matches(source) {
+				excluded = true
+				break
+			}
+		}
+		if excluded {
+			continue
+		}
+		rank := len(include)
+		for index, rule := range include {
+			if rule.matches(source) {
+				rank = index
+				break
+			}
+		}
+		if bestRank < 0 || rank < bestRank {
+			best = source
+			bestRank = rank
+		}
+	}
+	return best, bestRank >= 0
+}
+
+// enclosureRules parses the podcast's enclosure preference, falling back to
+// DefaultEnclosurePreference if it is empty or invalid.
+func (podcast *Podcast) enclosureRules() ([]enclosureRule, []enclosureRule) {
+	preference := podcast.EnclosurePreference
+	if len(preference) > 0 {
+		include, exclude, err := parseEnclosurePreference(preference)
+		if err == nil {
+			return include, exclude
+		}
+		log.Errorf("%s: ignoring the enclosure preference: %v", podcast.Label, err)
+	}
+	include, exclude, _ := parseEnclosurePreference(DefaultEnclosurePreference)
+	return include, exclude
+}
@@ -1,6 +1,10 @@
 package feed
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/avast/retry-go/v4"
 	log "github.com/sirupsen/logrus"
@@ -8,25 +12,242 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
 type EpisodeState int64
 
+// The states are saved as integers, new states must be added at the end.
 const (
+	// New episodes are waiting to be downloaded
 	New EpisodeState = iota
+	// Downloaded episodes are on disk and count against CountToKeep
 	Downloaded
+	// Deleted episodes were downloaded and their files have since been removed
 	Deleted
+	// Failed episodes could not be downloaded
+	Failed
+	// Skipped episodes are never downloaded
+	Skipped
+	// Pinned episodes are downloaded and kept, they do not count against CountToKeep
+	Pinned
+	// Expired episodes are no longer in the feed
+	Expired
+	// Played episodes have been listened to and are not downloaded again
+	Played
 )
 
+var stateNames = []string{"new", "downloaded", "deleted", "failed", "skipped", "pinned", "expired", "played"}
+
+func (state EpisodeState) String() string {
+	if state < 0 || int(state) >= len(stateNames) {
+		return fmt.Sprintf("EpisodeState(%d)", int64(state))
+	}
+	return stateNames[state]
+}
+
+// ParseEpisodeState converts a state name, as returned by String, into an EpisodeState.
+func ParseEpisodeState(name string) (EpisodeState, error) {
+	for state, stateName := range stateNames {
+		if strings.EqualFold(name, stateName) {
+			return EpisodeState(state), nil
+		}
+	}
+	return New, fmt.Errorf("unknown episode state %q, expected one of %s", name, strings.Join(stateNames, ", "))
+}
+
+// transitions lists the states each state may move to.
+var transitions = map[EpisodeState][]EpisodeState{
+	New:        {Downloaded, Deleted, Failed, Skipped, Pinned, Expired, Played},
+	Downloaded: {Deleted, Pinned, Played, Expired},
+	Deleted:    {New, Skipped, Pinned, Played, Expired},
+	Failed:     {New, Failed, Downloaded, Deleted, Skipped, Pinned, Expired},
+	Skipped:    {New, Deleted, Pinned, Expired},
+	Pinned:     {Downloaded, Deleted, Played},
+	Expired:    {New, Skipped, Pinned},
+	Played:     {New, Deleted, Pinned},
+}
+
+// CanTransition reports whether an episode in state from may move to state to.
+func CanTransition(from EpisodeState, to EpisodeState) bool {
+	for _, state := range transitions[from] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+// StateChange records a transition in an episode's History.
+type StateChange struct {
+	Time   time.Time
+	From   EpisodeState
+	To     EpisodeState
+	Reason string
+}
+
+// PartialSuffix is appended to an episode's filename while it is being downloaded.
+// The partial file is renamed into place only once the download is complete.
+const PartialSuffix = ".part"
+
+// validatorSuffix names the file holding the ETag or Last-Modified value of a
+// partial download, sent as If-Range when the download is resumed.
+const validatorSuffix = ".validator"
+
+// downloadAttempts is the number of tries within one sync, later syncs try again
+// after the episode's backoff.
+const downloadAttempts = 3
+
+// maxRetryBackoff caps the wait between attempts across syncs.
+const maxRetryBackoff = 7 * 24 * time.Hour
+
 type Episode struct {
 	GUID         string
 	URL          string
+	Type         string `yaml:",omitempty"` // MIME type of the chosen enclosure
 	State        EpisodeState
 	Title        string
 	Filename     string
 	Date         time.Time
+	DateInferred bool `yaml:",omitempty"` // the feed had no usable date, so Date was inferred from the feed order
 	PodcastLabel string
+	Length       int64         // enclosure length advertised by the feed, 0 if unknown
+	History      []StateChange `yaml:",omitempty"`
+	// Attempts is the number of syncs that failed to download the episode
+	Attempts int `yaml:",omitempty"`
+	// LastError and LastStatus describe the most recent failure, LastStatus is 0 if no response was received
+	LastError  string `yaml:",omitempty"`
+	LastStatus int    `yaml:",omitempty"`
+	// NextAttempt is the earliest time the episode is tried again
+	NextAttempt time.Time `yaml:",omitempty"`
+	// Replaced is set when the feed replaced the audio of a downloaded episode, it is downloaded again
+	Replaced bool `yaml:",omitempty"`
+	// PodcastGUID is the item's podcast:guid, used by IdentityPodcastGUID
+	PodcastGUID string `yaml:",omitempty"`
+	// the item's details from the feed, for templates, playlists and listings
+	Description string        `yaml:",omitempty"`
+	Link        string        `yaml:",omitempty"`
+	Image       string        `yaml:",omitempty"`
+	Duration    time.Duration `yaml:",omitempty"` // itunes:duration, 0 if unknown
+	Number      int           `yaml:",omitempty"` // itunes:episode
+	Season      int           `yaml:",omitempty"` // itunes:season
+	EpisodeType string        `yaml:",omitempty"` // itunes:episodeType, full, trailer or bonus
+	Explicit    bool          `yaml:",omitempty"`
+}
+
+// StatusError is returned when the server's response is worth retrying later,
+// for instance a 503.
+type StatusError struct {
+	StatusCode int
+	Status     string
+	URL        string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server returned %s for %s", e.Status, e.URL)
+}
+
+// errorStatus returns the HTTP status code behind err, or 0 if there was no response.
+func errorStatus(err error) int {
+	var statusError *StatusError
+	if errors.As(err, &statusError) {
+		return statusError.StatusCode
+	}
+	var validationError *ValidationError
+	if errors.As(err, &validationError) {
+		return validationError.StatusCode
+	}
+	return 0
+}
+
+// RecordFailure notes a failed download.  The next attempt is delayed by backoff,
+// doubled for each earlier attempt.  Once maxAttempts have failed, or if permanent
+// is set, the episode is marked Failed, or an episode on disk whose audio was
+// replaced keeps its file.  An episode on disk whose file went missing keeps its
+// state.  RecordFailure returns true if it gave up on the episode.
+func (episode *Episode) RecordFailure(err error, permanent bool, maxAttempts int, backoff time.Duration) bool {
+	episode.Attempts++
+	episode.LastError = err.Error()
+	episode.LastStatus = errorStatus(err)
+	delay := backoff
+	for attempt := 1; attempt < episode.Attempts && delay < maxRetryBackoff; attempt++ {
+		delay *= 2
+	}
+	episode.NextAttempt = time.Now().Add(min(delay, maxRetryBackoff))
+	if !permanent && (maxAttempts <= 0 || episode.Attempts < maxAttempts) {
+		return false
+	}
+	if episode.OnDisk() {
+		if episode.Replaced {
+			// the replaced audio could not be downloaded, keep the file on disk
+			log.Errorf("keeping %s, the replaced audio could not be downloaded: %s", episode.Filename, episode.LastError)
+			episode.Replaced = false
+		} else {
+			log.Errorf("could not download the missing file %s again: %s", episode.Filename, episode.LastError)
+		}
+		return true
+	}
+	reason := episode.LastError
+	if !permanent {
+		reason = fmt.Sprintf("gave up after %d attempts: %s", episode.Attempts, episode.LastError)
+	}
+	transitionErr := episode.Transition(Failed, reason)
+	if transitionErr != nil {
+		log.Error(transitionErr)
+		return false
+	}
+	return true
+}
+
+// ClearFailure forgets earlier failures once the episode has been downloaded.
+func (episode *Episode) ClearFailure() {
+	episode.Attempts = 0
+	episode.LastError = ""
+	episode.LastStatus = 0
+	episode.NextAttempt = time.Time{}
+}
+
+// Eligible reports whether a New episode's backoff has passed.
+func (episode *Episode) Eligible(now time.Time) bool {
+	return !now.Before(episode.NextAttempt)
+}
+
+// ShortIDLength is the number of hex digits in an episode's short ID.
+const ShortIDLength = 8
+
+// ShortID is a short, stable identifier of the episode derived from its GUID,
+// accepted by commands in place of the GUID.
+func (episode *Episode) ShortID() string {
+	sum := sha256.Sum256([]byte(episode.GUID))
+	return hex.EncodeToString(sum[:])[:ShortIDLength]
+}
+
+// HasID returns true if id is the episode's GUID or short ID.
+func (episode *Episode) HasID(id string) bool {
+	return id == episode.GUID || strings.EqualFold(id, episode.ShortID())
+}
+
+// Transition moves the episode to state to and records the change and reason in
+// its History.  An error is returned, and the state left alone, if the state
+// machine does not allow the transition.
+func (episode *Episode) Transition(to EpisodeState, reason string) error {
+	if !CanTransition(episode.State, to) {
+		return fmt.Errorf("episode %s can not change from %s to %s", episode.GUID, episode.State, to)
+	}
+	episode.History = append(episode.History, StateChange{
+		Time:   time.Now(),
+		From:   episode.State,
+		To:     to,
+		Reason: reason,
+	})
+	episode.State = to
+	return nil
+}
+
+// OnDisk reports whether the episode's file is expected to be in the podcast directory.
+func (episode *Episode) OnDisk() bool {
+	return episode.State == Downloaded || episode.State == Pinned
 }
 
 func (episode Episode) String() string {
@@ -34,28 +255,132 @@ func (episode Episode) String() string {
 		episode.GUID, episode.URL, episode.State, episode.Filename, episode.Date)
 }
 
-func (episode *Episode) Download(path string) error {
+// Download fetches the episode into path.  Data is written to path + PartialSuffix
+// and each attempt, or a later sync, resumes where the previous one stopped using
+// a Range request.  The file is renamed to path once the download is complete and
+// has been validated.  A *ValidationError is returned if the server refused the
+// request or the file is not audio, the rejected file is left at the error's Path.
+// If ctx is cancelled the download is aborted and the partial file removed.
+// A nil client uses Go's default HTTP client.
+func (episode *Episode) Download(ctx context.Context, client *HTTPClient, path string) error {
 	dir := filepath.Dir(path)
 	os.MkdirAll(dir, 0755)
+	partial := path + PartialSuffix
 
 	log.Debugf("Downloading %s to %s from %s", episode.Filename, dir, episode.URL)
+	var contentType string
 	err := retry.Do(
 		func() error {
-			resp, err := http.Get(episode.URL)
-			if err != nil {
-				return err
-			}
-			defer resp.Body.Close()
-
-			file, err := os.Create(path)
-			if err != nil {
-				return err
-			}
-			defer file.Close()
-			count, err := io.Copy(file, resp.Body)
-			log.Debugf("Downloaded %s to %s size %d", episode.Filename, path, count)
+			var err error
+			contentType, err = episode.downloadPartial(ctx, client, partial)
 			return err
+		},
+		retry.Context(ctx),
+		retry.Attempts(downloadAttempts),
+		retry.LastErrorOnly(true))
+	if ctx.Err() != nil {
+		log.Infof("download of %s cancelled, removing %s", episode.Filename, partial)
+		os.Remove(partial)
+		os.Remove(partial + validatorSuffix)
+		return ctx.Err()
+	}
+	if err != nil {
+		return err
+	}
+	err = validateDownload(partial, contentType, episode.Length)
+	if err != nil {
+		return err
+	}
+	os.Remove(partial + validatorSuffix)
+	return os.Rename(partial, path)
+}
+
+// downloadPartial fetches the remainder of the episode into the partial file and
+// returns the Content-Type of the response.
+func (episode *Episode) downloadPartial(ctx context.Context, client *HTTPClient, partial string) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, episode.URL, nil)
+	if err != nil {
+		return "", retry.Unrecoverable(err)
+	}
+	var offset int64
+	info, err := os.Stat(partial)
+	if err == nil && info.Size() > 0 {
+		// only resume if we know the partial file came from the same version of the episode
+		validator, err := os.ReadFile(partial + validatorSuffix)
+		if err == nil && len(validator) > 0 {
+			offset = info.Size()
+			request.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			request.Header.Set("If-Range", string(validator))
+		}
+	}
+
+	resp, err := client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case resp.StatusCode == http.StatusPartialContent:
+		start, err := contentRangeStart(resp.Header.Get("Content-Range"))
+		if err != nil || start != offset {
+			os.Remove(partial)
+			return "", fmt.Errorf("unexpected Content-Range %q resuming at %d", resp.Header.Get("Content-Range"), offset)
+		}
+		log.Debugf("resuming %s at byte %d", episode.Filename, offset)
+		flags |= os.O_APPEND
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// the partial file is as long as the episode, or longer, check which
+		if resp.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", offset) {
+			return "", nil
+		}
+		os.Remove(partial)
+		return "", fmt.Errorf("partial download of %s is larger than the episode", episode.Filename)
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		offset = 0
+		flags |= os.O_TRUNC
+	case resp.StatusCode == http.StatusRequestTimeout, resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode >= 500:
+		// worth trying again
+		return "", &StatusError{StatusCode: resp.StatusCode, Status: resp.Status, URL: episode.URL}
+	default:
+		return "", retry.Unrecoverable(&ValidationError{
+			Reason:     fmt.Sprintf("server returned %s for %s", resp.Status, episode.URL),
+			StatusCode: resp.StatusCode,
 		})
+	}
+
+	validator := resp.Header.Get("ETag")
+	if validator == "" || strings.HasPrefix(validator, "W/") {
+		// If-Range requires a strong validator
+		validator = resp.Header.Get("Last-Modified")
+	}
+	if validator != "" {
+		os.WriteFile(partial+validatorSuffix, []byte(validator), 0644)
+	} else {
+		os.Remove(partial + validatorSuffix)
+	}
+
+	file, err := os.OpenFile(partial, flags, 0644)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	count, err := io.Copy(file, client.limitReader(ctx, resp.Body))
+	log.Debugf("Downloaded %s to %s size %d", episode.Filename, partial, offset+count)
+	if err != nil {
+		return "", err
+	}
+	if resp.ContentLength >= 0 && count != resp.ContentLength {
+		return "", fmt.Errorf("short download of %s, got %d of %d bytes: %w", episode.Filename, count, resp.ContentLength, io.ErrUnexpectedEOF)
+	}
+	return resp.Header.Get("Content-Type"), nil
+}
 
-	return err
+// contentRangeStart returns the first byte position of a "bytes start-end/size" header.
+func contentRangeStart(contentRange string) (int64, error) {
+	var start, end int64
+	var size string
+	_, err := fmt.Sscanf(contentRange, "bytes %d-%d/%s", &start, &end, &size)
+	return start, err
 }
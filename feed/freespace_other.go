@@ -0,0 +1,12 @@
+//go:build !(linux || darwin || freebsd)
+
+package feed
+
+import (
+	"errors"
+)
+
+// freeSpace is not supported on this platform, MinFreeSpace is not enforced.
+func freeSpace(path string) (int64, error) {
+	return 0, errors.New("free space is not supported on this platform")
+}
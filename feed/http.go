@@ -0,0 +1,227 @@
+package feed
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultUserAgent is sent with every request unless the config sets another.
+const DefaultUserAgent = "castigate (podcast gateway)"
+
+// Default timeouts for new configs, a stalled server would otherwise hang a sync.
+const (
+	DefaultConnectTimeout = 30 * time.Second
+	DefaultReadTimeout    = 2 * time.Minute
+)
+
+// HTTPConfig configures the HTTP client used to fetch feeds and download episodes.
+// It is set globally in the config and individual settings may be overridden for
+// each podcast.
+type HTTPConfig struct {
+	UserAgent string
+	// ConnectTimeout limits connecting to the server, including the TLS handshake
+	ConnectTimeout time.Duration
+	// ReadTimeout aborts a request if no data arrives for this long
+	ReadTimeout time.Duration
+	// Proxy is the URL of the proxy, if empty the environment's proxy settings are used
+	Proxy string
+	// CABundle is a PEM file of extra certificate authorities, relative to the config file
+	CABundle           string
+	InsecureSkipVerify bool
+	Headers            map[string]string
+	Cookies            map[string]string
+}
+
+// Merge returns the settings with any set in override replacing them.  Headers and
+// cookies are combined, with the override taking precedence.
+func (h HTTPConfig) Merge(override *HTTPConfig) HTTPConfig {
+	if override == nil {
+		return h
+	}
+	merged := h
+	if override.UserAgent != "" {
+		merged.UserAgent = override.UserAgent
+	}
+	if override.ConnectTimeout != 0 {
+		merged.ConnectTimeout = override.ConnectTimeout
+	}
+	if override.ReadTimeout != 0 {
+		merged.ReadTimeout = override.ReadTimeout
+	}
+	if override.Proxy != "" {
+		merged.Proxy = override.Proxy
+	}
+	if override.CABundle != "" {
+		merged.CABundle = override.CABundle
+	}
+	merged.InsecureSkipVerify = h.InsecureSkipVerify || override.InsecureSkipVerify
+	merged.Headers = mergeMaps(h.Headers, override.Headers)
+	merged.Cookies = mergeMaps(h.Cookies, override.Cookies)
+	return merged
+}
+
+func mergeMaps(base map[string]string, override map[string]string) map[string]string {
+	if len(override) == 0 {
+		return base
+	}
+	merged := make(map[string]string, len(base)+len(override))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range override {
+		merged[key] = value
+	}
+	return merged
+}
+
+// HTTPClient sends requests with the settings of an HTTPConfig.  A nil HTTPClient
+// uses Go's default client with the default user agent.
+type HTTPClient struct {
+	client       *http.Client
+	settings     HTTPConfig
+	rateLimiters []*RateLimiter
+}
+
+// NewClient builds a client from the settings, configFilePath is used to find the CA bundle.
+func (h HTTPConfig) NewClient(configFilePath string) (*HTTPClient, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if h.ConnectTimeout > 0 {
+		dialer := &net.Dialer{Timeout: h.ConnectTimeout, KeepAlive: 30 * time.Second}
+		transport.DialContext = dialer.DialContext
+		transport.TLSHandshakeTimeout = h.ConnectTimeout
+	}
+	if h.Proxy != "" {
+		proxy, err := url.Parse(h.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse proxy URL %s: %w", h.Proxy, err)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+	if h.CABundle != "" || h.InsecureSkipVerify {
+		tlsConfig := &tls.Config{InsecureSkipVerify: h.InsecureSkipVerify}
+		if h.CABundle != "" {
+			bundle := resolvePath(configFilePath, h.CABundle)
+			pem, err := os.ReadFile(bundle)
+			if err != nil {
+				return nil, fmt.Errorf("could not read CA bundle: %w", err)
+			}
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in CA bundle %s", bundle)
+			}
+			tlsConfig.RootCAs = pool
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+	return &HTTPClient{
+		client:   &http.Client{Transport: transport},
+		settings: h,
+	}, nil
+}
+
+// WithRateLimiters returns a copy of the client limiting downloads by every non nil limiter.
+func (c *HTTPClient) WithRateLimiters(limiters ...*RateLimiter) *HTTPClient {
+	limited := &HTTPClient{client: http.DefaultClient}
+	if c != nil {
+		*limited = *c
+	}
+	limited.rateLimiters = make([]*RateLimiter, 0, len(limiters))
+	for _, limiter := range limiters {
+		if limiter != nil {
+			limited.rateLimiters = append(limited.rateLimiters, limiter)
+		}
+	}
+	return limited
+}
+
+// limitReader applies the client's rate limiters to reader.
+func (c *HTTPClient) limitReader(ctx context.Context, reader io.Reader) io.Reader {
+	if c == nil || len(c.rateLimiters) == 0 {
+		return reader
+	}
+	return &rateLimitedReader{ctx: ctx, reader: reader, limiters: c.rateLimiters}
+}
+
+// Do sends the request with the configured user agent, headers and cookies.
+func (c *HTTPClient) Do(request *http.Request) (*http.Response, error) {
+	if c == nil {
+		request.Header.Set("User-Agent", DefaultUserAgent)
+		return http.DefaultClient.Do(request)
+	}
+	userAgent := c.settings.UserAgent
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	request.Header.Set("User-Agent", userAgent)
+	for key, value := range c.settings.Headers {
+		request.Header.Set(key, value)
+	}
+	for name, value := range c.settings.Cookies {
+		request.AddCookie(&http.Cookie{Name: name, Value: value})
+	}
+	if c.settings.ReadTimeout <= 0 {
+		return c.client.Do(request)
+	}
+
+	// cancel the request if the server stops sending
+	ctx, cancel := context.WithCancel(request.Context())
+	body := &idleTimeoutBody{timeout: c.settings.ReadTimeout, cancel: cancel}
+	body.timer = time.AfterFunc(c.settings.ReadTimeout, func() {
+		body.expired.Store(true)
+		cancel()
+	})
+	resp, err := c.client.Do(request.WithContext(ctx))
+	if err != nil {
+		body.stop()
+		if body.expired.Load() {
+			return nil, fmt.Errorf("no response from %s in %s: %w", request.URL, c.settings.ReadTimeout, err)
+		}
+		return nil, err
+	}
+	body.ReadCloser = resp.Body
+	resp.Body = body
+	return resp, nil
+}
+
+// idleTimeoutBody cancels the request when a read has not returned data for timeout.
+type idleTimeoutBody struct {
+	io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	cancel  context.CancelFunc
+	expired atomic.Bool
+}
+
+func (b *idleTimeoutBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.timer.Reset(b.timeout)
+	}
+	if err != nil && err != io.EOF && b.expired.Load() {
+		err = fmt.Errorf("no data received in %s: %w", b.timeout, err)
+	}
+	return n, err
+}
+
+func (b *idleTimeoutBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.stop()
+	return err
+}
+
+func (b *idleTimeoutBody) stop() {
+	b.timer.Stop()
+	b.cancel()
+}
@@ -0,0 +1,188 @@
+package feed
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/mmcdole/gofeed"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+const (
+	// IdentityGUID identifies episodes by the item's GUID, or the hash of the enclosure URL without one
+	IdentityGUID = "guid"
+	// IdentityURL identifies episodes by the enclosure URL without its query, fragment and scheme
+	IdentityURL = "url"
+	// IdentityPodcastGUID identifies episodes by their podcast:guid, or GUID without one
+	IdentityPodcastGUID = "podcast-guid"
+	// IdentityTitleDate identifies episodes by their title and the day they were published
+	IdentityTitleDate = "title-date"
+)
+
+// ValidateIdentity returns an error if strategy is not an identity strategy, an
+// empty strategy is IdentityGUID.
+func ValidateIdentity(strategy string) error {
+	switch strategy {
+	case "", IdentityGUID, IdentityURL, IdentityPodcastGUID, IdentityTitleDate:
+		return nil
+	}
+	return fmt.Errorf("unknown identity %q, expected %s, %s, %s or %s", strategy, IdentityGUID, IdentityURL, IdentityPodcastGUID, IdentityTitleDate)
+}
+
+// hashURL is the GUID of an item without one.
+func hashURL(u string) string {
+	h := sha256.New()
+	h.Write([]byte(u))
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// normalizeURL strips the scheme, query and fragment of an enclosure URL, they
+// change with tracking parameters and the move to HTTPS.
+func normalizeURL(u string) string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return u
+	}
+	return strings.ToLower(parsed.Hostname()) + parsed.EscapedPath()
+}
+
+// itemPodcastGUID returns the item's podcast:guid, empty if it has none.
+func itemPodcastGUID(item *gofeed.Item) string {
+	for _, guid := range item.Extensions["podcast"]["guid"] {
+		if guid.Value != "" {
+			return guid.Value
+		}
+	}
+	return ""
+}
+
+// episodeIdentity returns what identifies the episode under the identity strategy,
+// episodes with the same identity are duplicates.  It falls back to the GUID when
+// the episode lacks what the strategy needs.
+func episodeIdentity(strategy string, episode *Episode) string {
+	switch strategy {
+	case IdentityURL:
+		if episode.URL != "" {
+			return "url:" + normalizeURL(episode.URL)
+		}
+	case IdentityPodcastGUID:
+		if episode.PodcastGUID != "" {
+			return "podcast-guid:" + strings.ToLower(episode.PodcastGUID)
+		}
+	case IdentityTitleDate:
+		title := strings.ToLower(strings.Join(strings.Fields(episode.Title), " "))
+		if title != "" {
+			if episode.Date.IsZero() || episode.DateInferred {
+				return "title:" + title
+			}
+			return "title-date:" + title + "@" + episode.Date.UTC().Format("2006-01-02")
+		}
+	}
+	return "guid:" + episode.GUID
+}
+
+// identityIndex maps the identities of the podcast's episodes to their keys,
+// preferring the episode DuplicateEpisodes would keep.
+func (podcast *Podcast) identityIndex(strategy string) map[string]string {
+	index := make(map[string]string, len(podcast.Episodes))
+	for _, group := range podcast.duplicateGroups(strategy) {
+		identity := episodeIdentity(strategy, group[0])
+		index[identity] = podcast.EpisodeKey(group[0])
+	}
+	return index
+}
+
+// keepRank orders duplicates, the lowest rank is kept: episodes on disk, then
+// played, then handled episodes and last those still to download.
+func keepRank(episode *Episode) int {
+	switch episode.State {
+	case Pinned:
+		return 0
+	case Downloaded:
+		return 1
+	case Played:
+		return 2
+	case Deleted, Skipped:
+		return 3
+	}
+	return 4
+}
+
+// duplicateGroups groups the podcast's episodes by identity, the episode to keep
+// first.
+func (podcast *Podcast) duplicateGroups(strategy string) [][]*Episode {
+	keys := make([]string, 0, len(podcast.Episodes))
+	for key := range podcast.Episodes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	groups := make(map[string][]*Episode)
+	identities := make([]string, 0)
+	for _, key := range keys {
+		episode := podcast.Episodes[key]
+		identity := episodeIdentity(strategy, episode)
+		if groups[identity] == nil {
+			identities = append(identities, identity)
+		}
+		groups[identity] = append(groups[identity], episode)
+	}
+	result := make([][]*Episode, 0, len(identities))
+	for _, identity := range identities {
+		group := groups[identity]
+		sort.SliceStable(group, func(i, j int) bool {
+			return keepRank(group[i]) < keepRank(group[j])
+		})
+		result = append(result, group)
+	}
+	return result
+}
+
+// DuplicateEpisodes returns the groups of episodes with the same identity under
+// strategy, the podcast's own if empty, in feed order.  The first episode of a
+// group is the one to keep, the one already downloaded if there is one.
+func (podcast *Podcast) DuplicateEpisodes(strategy string) [][]*Episode {
+	if strategy == "" {
+		strategy = podcast.Identity
+	}
+	order := make(map[*Episode]int, len(podcast.Episodes))
+	for index, episode := range podcast.OrderedEpisodes() {
+		order[episode] = index
+	}
+	duplicates := make([][]*Episode, 0)
+	for _, group := range podcast.duplicateGroups(strategy) {
+		if len(group) > 1 {
+			duplicates = append(duplicates, group)
+		}
+	}
+	sort.SliceStable(duplicates, func(i, j int) bool {
+		return order[duplicates[i][0]] < order[duplicates[j][0]]
+	})
+	return duplicates
+}
+
+// MergeDuplicate merges duplicate into keeper and removes it from the podcast.
+// The duplicate's file is removed, or moved to the trash directory, if it is not
+// the keeper's, and keeper takes its place in the queue.
+func (podcast *Podcast) MergeDuplicate(config Config, configFilePath string, keeper *Episode, duplicate *Episode) {
+	if duplicate.OnDisk() && duplicate.Filename != keeper.Filename {
+		directory := podcast.ResolveDirectory(configFilePath)
+		podcast.evict(config, configFilePath, directory, duplicate, "duplicate of "+keeper.Title)
+	}
+	if podcast.IsQueued(duplicate) {
+		queue := make([]string, 0, len(podcast.Queue))
+		for _, guid := range podcast.Queue {
+			if guid == duplicate.GUID {
+				guid = keeper.GUID
+			}
+			if !contains(queue, guid) {
+				queue = append(queue, guid)
+			}
+		}
+		podcast.Queue = queue
+	}
+	if keeper.PodcastGUID == "" {
+		keeper.PodcastGUID = duplicate.PodcastGUID
+	}
+	delete(podcast.Episodes, podcast.EpisodeKey(duplicate))
+}
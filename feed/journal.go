@@ -0,0 +1,104 @@
+package feed
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+)
+
+// JournalSuffix names the write-ahead journal kept next to the config file.
+// Checkpoints made during a sync are appended to the journal and replayed by Load,
+// the journal is removed when the whole config is next saved.
+const JournalSuffix = ".journal"
+
+// journalEntry is one line of the journal.  An entry either holds a whole podcast,
+// or a single episode of the podcast with the given label.
+type journalEntry struct {
+	Label   string
+	Podcast *Podcast `json:",omitempty"`
+	Key     string   `json:",omitempty"`
+	Episode *Episode `json:",omitempty"`
+}
+
+func (b *FileBackend) journalFilename() string {
+	return b.Filename + JournalSuffix
+}
+
+// CheckpointPodcast records the complete state of podcast in the journal.
+func (b *FileBackend) CheckpointPodcast(podcast *Podcast) error {
+	return b.appendJournal(journalEntry{Label: podcast.Label, Podcast: podcast})
+}
+
+// CheckpointEpisode records the state of a single episode in the journal.
+func (b *FileBackend) CheckpointEpisode(podcast *Podcast, key string, episode *Episode) error {
+	return b.appendJournal(journalEntry{Label: podcast.Label, Key: key, Episode: episode})
+}
+
+func (b *FileBackend) appendJournal(entry journalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	file, err := os.OpenFile(b.journalFilename(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(line)
+	if err == nil {
+		err = file.Sync()
+	}
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// replayJournal applies any checkpoints made since the config was last saved.
+// A torn final line, left by a crash in the middle of a write, is ignored.
+func (b *FileBackend) replayJournal(config *Config) error {
+	file, err := os.Open(b.journalFilename())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	log.Infof("replaying journal %s", b.journalFilename())
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	count := 0
+	for scanner.Scan() {
+		var entry journalEntry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			log.Warnf("ignoring incomplete journal entry %d: %v", count+1, err)
+			break
+		}
+		count++
+		podcast, err := config.FindPodcast(entry.Label)
+		if err != nil {
+			log.Warnf("journal refers to unknown podcast %s", entry.Label)
+			continue
+		}
+		if entry.Podcast != nil {
+			*podcast = *entry.Podcast
+		}
+		if entry.Episode != nil {
+			if podcast.Episodes == nil {
+				podcast.Episodes = make(map[string]*Episode)
+			}
+			podcast.Episodes[entry.Key] = entry.Episode
+		}
+	}
+	log.Debugf("replayed %d journal entries", count)
+	return scanner.Err()
+}
@@ -0,0 +1,69 @@
+package feed
+
+import (
+	"net/url"
+	"sync"
+)
+
+// Limiter bounds the number of concurrent downloads, both overall and per host.
+// A single Limiter is shared by every podcast in a sync so that the --jobs
+// setting applies to the whole run rather than to each podcast.
+type Limiter struct {
+	jobs    chan struct{}
+	perHost int
+	mutex   sync.Mutex
+	hosts   map[string]chan struct{}
+}
+
+// NewLimiter creates a limiter allowing jobs concurrent downloads and at most
+// perHost downloads from any one host.  A perHost of 0 means no per host limit.
+func NewLimiter(jobs int, perHost int) *Limiter {
+	if jobs < 1 {
+		jobs = 1
+	}
+	return &Limiter{
+		jobs:    make(chan struct{}, jobs),
+		perHost: perHost,
+		hosts:   make(map[string]chan struct{}),
+	}
+}
+
+// Jobs is the number of concurrent downloads allowed.
+func (l *Limiter) Jobs() int {
+	return cap(l.jobs)
+}
+
+// Acquire blocks until a download from rawURL may start.  The returned function
+// releases the slot and must be called when the download is complete.
+func (l *Limiter) Acquire(rawURL string) func() {
+	host := l.hostSlots(rawURL)
+	if host != nil {
+		host <- struct{}{}
+	}
+	l.jobs <- struct{}{}
+	return func() {
+		<-l.jobs
+		if host != nil {
+			<-host
+		}
+	}
+}
+
+func (l *Limiter) hostSlots(rawURL string) chan struct{} {
+	if l.perHost < 1 {
+		return nil
+	}
+	host := rawURL
+	u, err := url.Parse(rawURL)
+	if err == nil {
+		host = u.Host
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	slots, ok := l.hosts[host]
+	if !ok {
+		slots = make(chan struct{}, l.perHost)
+		l.hosts[host] = slots
+	}
+	return slots
+}
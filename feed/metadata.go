@@ -0,0 +1,76 @@
+package feed
+
+import (
+	"github.com/mmcdole/gofeed"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseDuration parses an itunes:duration, seconds or HH:MM:SS or MM:SS, 0 if it
+// can not be parsed.
+func parseDuration(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	var seconds float64
+	for _, part := range strings.Split(value, ":") {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil || n < 0 {
+			return 0
+		}
+		seconds = seconds*60 + n
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// parseExplicit returns true if an itunes:explicit value marks explicit content.
+func parseExplicit(value string) bool {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "yes", "true", "explicit":
+		return true
+	}
+	return false
+}
+
+// updateMetadata copies the item's description, link, image and iTunes details to
+// the episode, so they are available without the feed.
+func (episode *Episode) updateMetadata(item *gofeed.Item) {
+	episode.Description = strings.TrimSpace(item.Description)
+	episode.Link = item.Link
+	episode.Image = ""
+	if item.Image != nil {
+		episode.Image = item.Image.URL
+	}
+	itunes := item.ITunesExt
+	if itunes == nil {
+		return
+	}
+	if episode.Description == "" {
+		episode.Description = strings.TrimSpace(itunes.Summary)
+	}
+	if episode.Image == "" {
+		episode.Image = itunes.Image
+	}
+	episode.Duration = parseDuration(itunes.Duration)
+	episode.Number, _ = strconv.Atoi(strings.TrimSpace(itunes.Episode))
+	episode.Season, _ = strconv.Atoi(strings.TrimSpace(itunes.Season))
+	episode.EpisodeType = strings.ToLower(strings.TrimSpace(itunes.EpisodeType))
+	episode.Explicit = parseExplicit(itunes.Explicit)
+}
+
+// extinf is the episode's extended M3U line, -1 seconds if the duration is unknown.
+func (episode *Episode) extinf(podcastTitle string) string {
+	seconds := -1
+	if episode.Duration > 0 {
+		seconds = int(episode.Duration.Round(time.Second).Seconds())
+	}
+	title := episode.Title
+	if podcastTitle != "" {
+		title = podcastTitle + " - " + title
+	}
+	// a line break would end the entry
+	title = strings.Join(strings.Fields(title), " ")
+	return "#EXTINF:" + strconv.Itoa(seconds) + "," + title
+}
@@ -0,0 +1,96 @@
+package feed
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// FeedMove records a change of a podcast's feed URL.
+type FeedMove struct {
+	Time   time.Time
+	From   string
+	To     string
+	Reason string
+}
+
+// permanentRedirect returns where the response's permanent redirects, 301 and 308,
+// led to, or "" if the request was not permanently redirected.  A temporary
+// redirect ends the chain, the URL before it is where the feed now lives.
+func permanentRedirect(resp *http.Response) string {
+	requests := make([]*http.Request, 0)
+	for request := resp.Request; request != nil; {
+		requests = append([]*http.Request{request}, requests...)
+		if request.Response == nil {
+			break
+		}
+		request = request.Response.Request
+	}
+	moved := ""
+	for _, request := range requests[1:] {
+		code := request.Response.StatusCode
+		if code != http.StatusMovedPermanently && code != http.StatusPermanentRedirect {
+			break
+		}
+		moved = request.URL.String()
+	}
+	return moved
+}
+
+// moveFeed points the podcast at a new feed URL, recording the old one in
+// FeedHistory.  If config.ConfirmFeedMoves is set the new URL is only saved as
+// PendingFeed, for AcceptFeedMove.
+func (podcast *Podcast) moveFeed(config Config, to string, reason string) {
+	if base, err := url.Parse(podcast.Feed); err == nil {
+		if resolved, err := base.Parse(to); err == nil {
+			to = resolved.String()
+		}
+	}
+	if to == "" || to == podcast.Feed || to == podcast.PendingFeed {
+		return
+	}
+	for _, move := range podcast.FeedHistory {
+		if move.From == to {
+			// moving back to an old feed is more likely a loop than a real move
+			log.Warnf("%s: not moving the feed back to %s (%s)", podcast.Label, to, reason)
+			return
+		}
+	}
+	if config.ConfirmFeedMoves {
+		log.Warnf("%s: the feed moved to %s (%s), confirm with castigate edit %s --accept-feed-move",
+			podcast.Label, to, reason, podcast.Label)
+		podcast.PendingFeed = to
+		return
+	}
+	log.Infof("%s: the feed moved from %s to %s (%s)", podcast.Label, podcast.Feed, to, reason)
+	podcast.SetFeed(to, reason)
+}
+
+// SetFeed changes the podcast's feed URL, recording the old one in FeedHistory.
+// The validators of the old feed are dropped.
+func (podcast *Podcast) SetFeed(to string, reason string) {
+	if to == podcast.Feed {
+		return
+	}
+	podcast.FeedHistory = append(podcast.FeedHistory, FeedMove{
+		Time:   time.Now(),
+		From:   podcast.Feed,
+		To:     to,
+		Reason: reason,
+	})
+	podcast.Feed = to
+	podcast.PendingFeed = ""
+	podcast.ETag = ""
+	podcast.LastModified = ""
+}
+
+// AcceptFeedMove moves the podcast to its PendingFeed.
+func (podcast *Podcast) AcceptFeedMove() error {
+	if podcast.PendingFeed == "" {
+		return fmt.Errorf("the feed of %s has not moved", podcast.Label)
+	}
+	podcast.SetFeed(podcast.PendingFeed, "move accepted")
+	return nil
+}
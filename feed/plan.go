@@ -0,0 +1,266 @@
+package feed
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// SyncPlan describes what a sync of a podcast will do.  The plan is computed from the
+// podcast's current state and the files on disk, without changing either, so it is
+// used by Sync itself and to show what a sync would do.
+type SyncPlan struct {
+	Podcast *Podcast
+	// Directory is the absolute path of the podcast's directory
+	Directory string
+	// Ordered holds every episode in download order
+	Ordered []*Episode
+	// Deleted are Downloaded or Pinned episodes whose files are no longer on disk
+	Deleted []*Episode
+	// Moved are episodes of an archived podcast whose files are no longer on disk,
+	// they have been moved to other storage and are not marked Deleted
+	Moved []*Episode
+	// Replaced are episodes on disk whose audio was replaced in the feed, they are downloaded again
+	Replaced []*Episode
+	// Aged are downloaded episodes older than MaxAge, they will be removed
+	Aged []*Episode
+	// Candidates are the episodes that may be downloaded, in order
+	Candidates []*Episode
+	// Count is the number of candidates to download
+	Count int
+	// Deferred is true if downloads wait for a download window
+	Deferred bool
+}
+
+// Plan works out what Sync would do after the feed has been updated.
+func (podcast *Podcast) Plan(config Config, configFilePath string) *SyncPlan {
+	plan := &SyncPlan{
+		Podcast:    podcast,
+		Directory:  podcast.ResolveDirectory(configFilePath),
+		Ordered:    podcast.OrderedEpisodes(),
+		Deleted:    make([]*Episode, 0),
+		Moved:      make([]*Episode, 0),
+		Aged:       make([]*Episode, 0),
+		Replaced:   make([]*Episode, 0),
+		Candidates: make([]*Episode, 0),
+	}
+	countOfExistingFiles := 0
+	now := time.Now()
+	var oldest time.Time
+	if maxAge := podcast.GetMaxAge(config); maxAge > 0 {
+		oldest = now.Add(-maxAge)
+	}
+	for _, episode := range plan.Ordered {
+		tooOld := episode.Date.Before(oldest)
+		if episode.OnDisk() {
+			if !IsFileExist(path.Join(plan.Directory, episode.Filename)) {
+				if podcast.IsArchive() {
+					plan.Moved = append(plan.Moved, episode)
+				} else {
+					plan.Deleted = append(plan.Deleted, episode)
+				}
+			} else if episode.Replaced {
+				if episode.Eligible(now) {
+					plan.Replaced = append(plan.Replaced, episode)
+				}
+				if episode.State == Downloaded {
+					countOfExistingFiles++
+				}
+			} else if episode.State == Downloaded && tooOld {
+				plan.Aged = append(plan.Aged, episode)
+			} else if episode.State == Downloaded {
+				// pinned episodes are kept in addition to CountToKeep
+				countOfExistingFiles++
+			}
+		}
+		if episode.State == New && episode.Eligible(now) && !podcast.IsManual() && !tooOld {
+			plan.Candidates = append(plan.Candidates, episode)
+		}
+	}
+	plan.Count = max(podcast.GetCountToKeep(config)-countOfExistingFiles, 0)
+	if podcast.Prunes() {
+		// newer episodes replace the downloaded ones, download the new episodes
+		// among the first CountToKeep of the downloaded and new episodes
+		plan.Count = 0
+		rank := 0
+		deleted := make(map[*Episode]bool)
+		for _, episode := range append(plan.Deleted, plan.Aged...) {
+			deleted[episode] = true
+		}
+		candidates := make(map[*Episode]bool)
+		for _, episode := range plan.Candidates {
+			candidates[episode] = true
+		}
+		for _, episode := range plan.Ordered {
+			if rank >= podcast.GetCountToKeep(config) {
+				break
+			}
+			if candidates[episode] {
+				plan.Count++
+				rank++
+			} else if episode.State == Downloaded && !deleted[episode] {
+				rank++
+			}
+		}
+	}
+	if podcast.IsManual() {
+		// every queued episode is downloaded, whatever CountToKeep
+		for _, episode := range podcast.QueuedEpisodes() {
+			if episode.State == New && episode.Eligible(now) {
+				plan.Candidates = append(plan.Candidates, episode)
+			}
+		}
+		plan.Count = len(plan.Candidates)
+	}
+	if podcast.IsArchive() {
+		// the whole back catalogue is downloaded, a batch at a time
+		plan.Count = len(plan.Candidates)
+		if config.ArchiveBatchSize > 0 {
+			plan.Count = min(plan.Count, config.ArchiveBatchSize)
+		}
+	}
+	if config.Offline {
+		plan.Count = 0
+	}
+	if !InDownloadWindow(podcast.GetDownloadWindows(config), now) {
+		plan.Deferred = plan.Count > 0
+		plan.Count = 0
+	}
+	return plan
+}
+
+// Downloads are the episodes the sync will download, assuming every download succeeds.
+func (plan *SyncPlan) Downloads() []*Episode {
+	return plan.Candidates[:min(plan.Count, len(plan.Candidates))]
+}
+
+// Evictions are the downloaded episodes a pruning sync will remove, assuming every download succeeds.
+func (plan *SyncPlan) Evictions(config Config) []*Episode {
+	if !plan.Podcast.Prunes() {
+		return make([]*Episode, 0)
+	}
+	deleted := make(map[*Episode]bool)
+	for _, episode := range append(plan.Deleted, plan.Aged...) {
+		deleted[episode] = true
+	}
+	downloads := make(map[*Episode]bool)
+	for _, episode := range plan.Downloads() {
+		downloads[episode] = true
+	}
+	return evictions(plan.Ordered, func(episode *Episode) bool {
+		return (episode.State == Downloaded && !deleted[episode]) || downloads[episode]
+	}, plan.Podcast.GetCountToKeep(config))
+}
+
+// evictions returns the downloaded episodes after the newest keep of the episodes
+// for which downloaded is true, whatever the podcast's start, so the oldest
+// downloads are removed.
+func evictions(ordered []*Episode, downloaded func(*Episode) bool, keep int) []*Episode {
+	evicted := make([]*Episode, 0)
+	rank := 0
+	for _, episode := range newestFirst(ordered) {
+		if !downloaded(episode) {
+			continue
+		}
+		if rank >= keep && episode.State == Downloaded {
+			evicted = append(evicted, episode)
+		}
+		rank++
+	}
+	return evicted
+}
+
+// Playlist is the contents of the playlist after the sync, assuming every download succeeds.
+func (plan *SyncPlan) Playlist(config Config) []*Episode {
+	evicted := make(map[*Episode]bool)
+	for _, episode := range plan.Evictions(config) {
+		evicted[episode] = true
+	}
+	deleted := make(map[*Episode]bool)
+	for _, episode := range append(append(plan.Deleted, plan.Moved...), plan.Aged...) {
+		deleted[episode] = true
+	}
+	downloads := make(map[*Episode]bool)
+	for _, episode := range plan.Downloads() {
+		downloads[episode] = true
+	}
+	playlist := make([]*Episode, 0)
+	for _, episode := range plan.Podcast.PlaylistEpisodes() {
+		if (episode.OnDisk() && !deleted[episode]) || downloads[episode] {
+			if evicted[episode] {
+				continue
+			}
+			playlist = append(playlist, episode)
+		}
+	}
+	return playlist
+}
+
+// OrderedEpisodes returns the episodes in download order, oldest or newest first
+// depending on Start.  Ties are broken on the GUID so the order is stable.
+func (podcast *Podcast) OrderedEpisodes() []*Episode {
+	orderedEpisodes := make([]*Episode, 0, len(podcast.Episodes))
+	for _, episode := range podcast.Episodes {
+		orderedEpisodes = append(orderedEpisodes, episode)
+	}
+	sort.Slice(orderedEpisodes, func(a, b int) bool {
+		// break ties on the GUID so the order does not depend on map iteration
+		if orderedEpisodes[a].Date.Equal(orderedEpisodes[b].Date) {
+			return orderedEpisodes[a].GUID < orderedEpisodes[b].GUID
+		}
+		after := orderedEpisodes[a].Date.After(orderedEpisodes[b].Date)
+		if podcast.Start == "newest" {
+			return after
+		} else {
+			return !after
+		}
+	})
+	return orderedEpisodes
+}
+
+// newestFirst returns the episodes sorted by date, newest first.
+func newestFirst(episodes []*Episode) []*Episode {
+	sorted := append([]*Episode(nil), episodes...)
+	sort.SliceStable(sorted, func(a, b int) bool {
+		return sorted[a].Date.After(sorted[b].Date)
+	})
+	return sorted
+}
+
+// GetCountToKeep is the number of episodes to keep on disk, the podcast's
+// CountToKeep or the config default.
+func (podcast *Podcast) GetCountToKeep(config Config) int {
+	if podcast.CountToKeep > 0 {
+		return podcast.CountToKeep
+	}
+	return config.DefaultCountToKeep
+}
+
+// GetDownloadWindows returns the podcast's download windows, or the config's if it has none.
+func (podcast *Podcast) GetDownloadWindows(config Config) []string {
+	if len(podcast.DownloadWindows) > 0 {
+		return podcast.DownloadWindows
+	}
+	return config.DownloadWindows
+}
+
+// GetMaxAge returns the podcast's MaxAge, or the config's if it has none.  Manual
+// and archived podcasts keep their episodes whatever their age.
+func (podcast *Podcast) GetMaxAge(config Config) time.Duration {
+	if podcast.IsManual() || podcast.IsArchive() {
+		return 0
+	}
+	if podcast.MaxAge > 0 {
+		return podcast.MaxAge
+	}
+	return config.MaxAge
+}
+
+// PlaylistFilename is the name of the podcast's m3u playlist, made from the title.
+func (podcast *Podcast) PlaylistFilename() string {
+	playlistFilename := fmt.Sprintf("%s.m3u", podcast.Title)
+	re := regexp.MustCompile(`[^A-Za-z0-9_\-\.]`)
+	return re.ReplaceAllString(playlistFilename, "-")
+}
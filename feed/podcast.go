@@ -2,28 +2,62 @@ package feed
 
 import (
 	"bytes"
-	"crypto/sha256"
+	"context"
 	"errors"
 	"fmt"
 	"github.com/mmcdole/gofeed"
 	log "github.com/sirupsen/logrus"
+	"io"
+	"net/http"
 	"os"
 	"path"
 	"path/filepath"
 	"regexp"
-	"sort"
+	"strings"
+	"sync"
 	"text/template"
 	"time"
 )
 
 type Podcast struct {
-	Label       string
-	Title       string
-	Feed        string
-	Directory   string
-	CountToKeep int
-	Start       string // oldest or newest
-	Episodes    map[string]*Episode
+	Label        string
+	Title        string
+	Feed         string
+	Directory    string
+	CountToKeep  int
+	Start        string // oldest or newest
+	Tags         []string
+	ETag         string // validators from the last fetch of the feed
+	LastModified string
+	// HTTP overrides the config's HTTP settings for this podcast
+	HTTP *HTTPConfig `yaml:"http,omitempty"`
+	// RateLimit is this podcast's download bandwidth in bytes per second, 0 for no limit
+	RateLimit ByteSize
+	// DownloadWindows override the config's download windows
+	DownloadWindows []string
+	// Mode is ModeAuto, the default, or ModeManual to only download queued episodes
+	Mode string
+	// MaxAge overrides the config's MaxAge for this podcast
+	MaxAge time.Duration
+	// MaxBytes is the size of the podcast's downloads, 0 for no limit
+	MaxBytes ByteSize
+	// ReplacedAudio is ReplacedAudioKeep, the default, or ReplacedAudioRedownload to
+	// download episodes again when the feed replaces their audio
+	ReplacedAudio string
+	// Prune removes the oldest downloads beyond CountToKeep so newer episodes are downloaded
+	Prune bool
+	// EnclosurePreference ranks the enclosures of an episode, for instance
+	// ["audio/mpeg", "audio/mp4", "!video/*"], DefaultEnclosurePreference if empty
+	EnclosurePreference []string
+	// Identity is the strategy matching feed items to episodes, IdentityGUID if empty
+	Identity string
+	// FeedHistory records the moves of the feed, the oldest first
+	FeedHistory []FeedMove `yaml:",omitempty"`
+	// PendingFeed is where the feed moved, waiting for confirmation when Config.ConfirmFeedMoves is set
+	PendingFeed string `yaml:",omitempty"`
+	// Queue holds the GUIDs of the episodes to download in ModeManual, in playlist order
+	Queue    []string
+	Episodes map[string]*Episode
 }
 
 func IsFileExist(path string) bool {
@@ -38,6 +72,37 @@ func IsFileExist(path string) bool {
 
 }
 
+// writeFileAtomic writes data to a temporary file and renames it over path,
+// so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	closeErr := tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
 func IsDirectory(path string) (bool, error) {
 	fileInfo, err := os.Stat(path)
 	if err != nil {
@@ -46,148 +111,621 @@ func IsDirectory(path string) (bool, error) {
 	return fileInfo.IsDir(), nil
 }
 
-func (podcast *Podcast) Sync(config Config, configFilePath string) error {
-	if podcast.Episodes == nil {
-		podcast.Episodes = make(map[string]*Episode, 0)
-	}
+// Sync refreshes the feed, downloads new episodes and writes the playlist.  If ctx
+// is cancelled, in flight downloads are aborted and the playlist is written for the
+// episodes downloaded so far, so the podcast's state can still be saved.
+func (podcast *Podcast) Sync(ctx context.Context, config Config, configFilePath string) error {
 	if podcast.Label == "" {
 		podcast.Label = path.Base(podcast.Directory)
 	}
 	if podcast.Start == "" {
 		podcast.Start = "oldest"
 	}
-	feed, err := podcast.UpdateFromRSS(config)
+	_, err := podcast.UpdateFromRSS(ctx, config, configFilePath)
 	if err != nil {
 		return err
 	}
+	client, err := podcast.downloadClient(config, configFilePath)
+	if err != nil {
+		return err
+	}
+	if config.DiskBudget == nil {
+		config.DiskBudget = NewDiskBudget(config, configFilePath)
+	}
+
+	plan := podcast.Plan(config, configFilePath)
+	log.Debugf("podcast directory is %s", plan.Directory)
 
-	podcastDirectory := podcast.Directory
-	if filepath.IsLocal(podcastDirectory) {
-		absPath, err := filepath.Abs(configFilePath)
+	// Update any downloaded -> deleted, deleted episodes have been listened to and leave the queue
+	for _, episode := range plan.Deleted {
+		err = episode.Transition(Deleted, "file removed")
 		if err != nil {
-			log.Fatalf("could not get absolute path of %s", configFilePath)
+			log.Error(err)
 		}
-		podcastDirectory = filepath.Join(absPath, podcastDirectory)
+		podcast.Dequeue(episode)
+	}
+	for _, episode := range plan.Aged {
+		podcast.evict(config, configFilePath, plan.Directory, episode, "older than "+podcast.GetMaxAge(config).String())
 	}
 
-	// Update any downloaded -> deleted
-	countOfExistingFiles := podcast.GetExistingFiles(podcastDirectory)
+	// download whatever we need
+	if config.Offline {
+		log.Infof("offline, not downloading episodes of %s", podcast.Label)
+	}
+	if plan.Deferred {
+		log.Infof("outside the download windows %v, deferring downloads of %s", podcast.GetDownloadWindows(config), podcast.Label)
+	}
+	log.Infof("downloading %d episodes", plan.Count)
+	var pace time.Duration
+	if podcast.IsArchive() {
+		pace = config.ArchiveDelay
+	}
+	podcast.downloadEpisodes(ctx, config, client, plan.Directory, podcast.quarantineDirectory(config, configFilePath), plan.Candidates, plan.Count, pace)
+	if !config.Offline && !plan.Deferred && len(plan.Replaced) > 0 {
+		log.Infof("downloading the replaced audio of %d episodes", len(plan.Replaced))
+		podcast.downloadEpisodes(ctx, config, client, plan.Directory, podcast.quarantineDirectory(config, configFilePath), plan.Replaced, len(plan.Replaced), pace)
+	}
+	if podcast.Prunes() && ctx.Err() == nil {
+		podcast.prune(config, configFilePath, plan.Directory)
+	}
 
-	// Sort, and download whatever we need
-	orderedEpisodes := make([]*Episode, 0, len(podcast.Episodes))
-	for _, episode := range podcast.Episodes {
-		orderedEpisodes = append(orderedEpisodes, episode)
+	err = podcast.WritePlaylist(config, plan.Directory)
+	if err != nil {
+		return err
 	}
-	sort.Slice(orderedEpisodes, func(a, b int) bool {
-		after := orderedEpisodes[a].Date.After(orderedEpisodes[b].Date)
-		if podcast.Start == "oldest" {
-			return !after
-		} else {
-			return after
-		}
-	})
-
-	log.Debugf("podcast directory is %s", podcastDirectory)
-	// loop through and download what we can
-	countToKeep := config.DefaultCountToKeep
-	if podcast.CountToKeep > 0 {
-		countToKeep = podcast.CountToKeep
-	}
-	countToDownload := countToKeep - countOfExistingFiles
-	log.Infof("downloading %d episodes", countToDownload)
-	for _, episode := range orderedEpisodes {
-		if episode.State == New && countToDownload > 0 {
-			path.Base(configFilePath)
-
-			log.Infof("downloading %s from %s", episode.Filename, episode.URL)
-			err = episode.Download(path.Join(podcastDirectory, episode.Filename))
-			if err == nil {
-				episode.State = Downloaded
-				countToDownload--
-			} else {
-				log.Errorf("could not download episode %s from %s: %s", episode.Filename, episode.URL, err)
+	return ctx.Err()
+}
+
+// prune removes the downloaded episodes older than the newest CountToKeep, or
+// moves them to the trash directory if one is configured, and marks them Deleted.
+func (podcast *Podcast) prune(config Config, configFilePath string, directory string) {
+	evicted := evictions(podcast.OrderedEpisodes(), func(episode *Episode) bool {
+		return episode.State == Downloaded && IsFileExist(path.Join(directory, episode.Filename))
+	}, podcast.GetCountToKeep(config))
+	for _, episode := range evicted {
+		podcast.evict(config, configFilePath, directory, episode, "pruned")
+	}
+	// then the oldest downloads until the podcast is within MaxBytes
+	if podcast.MaxBytes > 0 {
+		used := podcast.diskUsage(directory)
+		ordered := newestFirst(podcast.OrderedEpisodes())
+		for index := len(ordered) - 1; index >= 0 && used > int64(podcast.MaxBytes); index-- {
+			episode := ordered[index]
+			if episode.State == Downloaded {
+				used -= fileSize(path.Join(directory, episode.Filename))
+				podcast.evict(config, configFilePath, directory, episode, "pruned to "+podcast.MaxBytes.String())
 			}
 		}
 	}
-	// save an m3u file
-	playlistFilename := fmt.Sprintf("%s.m3u", feed.Title)
-	re := regexp.MustCompile(`[^A-Za-z0-9_\-\.]`)
+}
 
-	playlistFilename = re.ReplaceAllString(playlistFilename, "-")
-	fid, err := os.Create(path.Join(podcast.Directory, playlistFilename))
+// evict removes the episode's file, or moves it to the trash directory if one is
+// configured, and marks the episode Deleted for reason.
+func (podcast *Podcast) evict(config Config, configFilePath string, directory string, episode *Episode, reason string) {
+	reason, err := podcast.removeFile(config, configFilePath, directory, episode, reason)
 	if err != nil {
-		fmt.Errorf("could not create the playlist: %s", err)
+		log.Error(err)
+		return
 	}
-	defer fid.Close()
-	for _, episode := range orderedEpisodes {
-		if episode.State == Downloaded {
-			_, err = fid.WriteString(episode.Filename + "\n")
+	err = episode.Transition(Deleted, reason)
+	if err != nil {
+		log.Error(err)
+	}
+	podcast.checkpoint(config, episode)
+}
+
+// Mark moves the episode to state.  An episode on disk marked Deleted or Played
+// has its file removed, or moved to the trash directory, so no file is left
+// untracked, and Played episodes leave the queue.
+func (podcast *Podcast) Mark(config Config, configFilePath string, episode *Episode, state EpisodeState) error {
+	reason := "marked " + state.String()
+	if episode.OnDisk() && (state == Deleted || state == Played) {
+		var err error
+		reason, err = podcast.removeFile(config, configFilePath, podcast.ResolveDirectory(configFilePath), episode, reason)
+		if err != nil {
+			return err
+		}
+	}
+	if state == Played {
+		podcast.Dequeue(episode)
+	}
+	err := episode.Transition(state, reason)
+	if err != nil {
+		return err
+	}
+	if state == New {
+		episode.ClearFailure()
+	}
+	return nil
+}
+
+// removeFile removes the episode's file, or moves it to the trash directory if one
+// is configured, and returns reason with where the file went.
+func (podcast *Podcast) removeFile(config Config, configFilePath string, directory string, episode *Episode, reason string) (string, error) {
+	filename := path.Join(directory, episode.Filename)
+	size := fileSize(filename)
+	var err error
+	if config.TrashDirectory == "" {
+		log.Infof("%s: %s, removing %s", podcast.Label, reason, filename)
+		err = os.Remove(filename)
+	} else {
+		trash := filepath.Join(resolvePath(configFilePath, config.TrashDirectory), podcast.Label)
+		log.Infof("%s: %s, moving %s to %s", podcast.Label, reason, filename, trash)
+		err = os.MkdirAll(trash, 0755)
+		if err == nil {
+			err = os.Rename(filename, filepath.Join(trash, episode.Filename))
 		}
+		reason += ", moved to " + trash
 	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return reason, fmt.Errorf("could not remove %s: %w", filename, err)
+	}
+	config.DiskBudget.Adjust(-size)
+	return reason, nil
+}
 
+// Fetch downloads a single episode regardless of its state and CountToKeep, and
+// pins it so it is kept and stays in the playlist.  An episode already on disk is
+// pinned without downloading it again.  A failed download is recorded like one
+// during a sync, unless ctx was cancelled.
+func (podcast *Podcast) Fetch(ctx context.Context, config Config, configFilePath string, episode *Episode) error {
+	directory := podcast.ResolveDirectory(configFilePath)
+	filename := path.Join(directory, episode.Filename)
+	if !episode.OnDisk() || !IsFileExist(filename) {
+		client, err := podcast.downloadClient(config, configFilePath)
+		if err != nil {
+			return err
+		}
+		if episode.OnDisk() {
+			log.Warnf("missing file %s, re-downloading it from %s", episode.Filename, episode.URL)
+		} else {
+			log.Infof("fetching %s from %s", episode.Filename, episode.URL)
+		}
+		err = episode.Download(ctx, client, filename)
+		var validationError *ValidationError
+		if errors.As(err, &validationError) {
+			episode.RecordFailure(validationError, validationError.Permanent(), config.MaxAttempts, config.RetryBackoff)
+			quarantine(validationError.Path, podcast.quarantineDirectory(config, configFilePath))
+			return err
+		}
+		if err != nil {
+			if ctx.Err() == nil {
+				episode.RecordFailure(err, false, config.MaxAttempts, config.RetryBackoff)
+			}
+			return fmt.Errorf("could not fetch %s: %w", episode.Title, err)
+		}
+		episode.ClearFailure()
+	}
+	if episode.State != Pinned {
+		err := episode.Transition(Pinned, "fetched")
+		if err != nil {
+			return err
+		}
+	}
+	return podcast.WritePlaylist(config, directory)
+}
+
+// WritePlaylist writes the m3u playlist of the episodes on disk, in order, to directory.
+func (podcast *Podcast) WritePlaylist(config Config, directory string) error {
+	playlist := bytes.Buffer{}
+	if config.ExtendedPlaylist {
+		playlist.WriteString("#EXTM3U\n")
+	}
+	for _, episode := range podcast.PlaylistEpisodes() {
+		// files of archived podcasts may have been moved elsewhere
+		if episode.OnDisk() && (!podcast.IsArchive() || IsFileExist(path.Join(directory, episode.Filename))) {
+			if config.ExtendedPlaylist {
+				playlist.WriteString(episode.extinf(podcast.Title) + "\n")
+			}
+			playlist.WriteString(episode.Filename + "\n")
+		}
+	}
+	err := writeFileAtomic(path.Join(directory, podcast.PlaylistFilename()), playlist.Bytes())
+	if err != nil {
+		return fmt.Errorf("could not create the playlist: %w", err)
+	}
 	return nil
 }
 
-func (podcast *Podcast) GetExistingFiles(podcastDirectory string) int {
-	countOfExistingFiles := 0
+// downloadClient is the podcast's HTTP client limited by the global and podcast rate limits.
+func (podcast *Podcast) downloadClient(config Config, configFilePath string) (*HTTPClient, error) {
+	client, err := podcast.HTTPClient(config, configFilePath)
+	if err != nil {
+		return nil, err
+	}
+	globalLimiter := config.RateLimiter
+	if globalLimiter == nil {
+		globalLimiter = NewRateLimiter(config.RateLimit)
+	}
+	return client.WithRateLimiters(globalLimiter, NewRateLimiter(podcast.RateLimit)), nil
+}
+
+// quarantineDirectory is where the podcast's downloads failing validation are moved.
+func (podcast *Podcast) quarantineDirectory(config Config, configFilePath string) string {
+	quarantineDirectory := config.QuarantineDirectory
+	if quarantineDirectory == "" {
+		quarantineDirectory = DefaultQuarantineDirectory
+	}
+	return filepath.Join(resolvePath(configFilePath, quarantineDirectory), podcast.Label)
+}
+
+// HTTPClient builds the client for the podcast from the config's HTTP settings
+// and the podcast's overrides.
+func (podcast *Podcast) HTTPClient(config Config, configFilePath string) (*HTTPClient, error) {
+	client, err := config.HTTP.Merge(podcast.HTTP).NewClient(configFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not configure HTTP for %s: %w", podcast.Label, err)
+	}
+	return client, nil
+}
+
+// ResolveDirectory returns the podcast's directory, relative to the directory of the config file.
+func (podcast *Podcast) ResolveDirectory(configFilePath string) string {
+	return resolvePath(configFilePath, podcast.Directory)
+}
+
+// FindEpisode returns the episode with the GUID or short ID.
+func (podcast *Podcast) FindEpisode(id string) (*Episode, error) {
+	if episode, ok := podcast.Episodes[id]; ok {
+		return episode, nil
+	}
+	var found *Episode
 	for _, episode := range podcast.Episodes {
-		fn := path.Join(podcastDirectory, episode.Filename)
-		if episode.State == Downloaded && !IsFileExist(fn) {
-			episode.State = Deleted
+		if episode.HasID(id) {
+			if found != nil && found != episode {
+				return nil, fmt.Errorf("episode ID %s of %s is ambiguous", id, podcast.Label)
+			}
+			found = episode
 		}
-		if episode.State == Downloaded {
-			countOfExistingFiles++
+	}
+	if found == nil {
+		return nil, fmt.Errorf("could not find episode %s of %s", id, podcast.Label)
+	}
+	return found, nil
+}
+
+// resolvePath returns p relative to the directory of the config file, unless p is absolute.
+func resolvePath(configFilePath string, p string) string {
+	if !filepath.IsLocal(p) {
+		return p
+	}
+	absPath, err := filepath.Abs(configFilePath)
+	if err != nil {
+		log.Fatalf("could not get absolute path of %s", configFilePath)
+	}
+	return filepath.Join(absPath, p)
+}
+
+// downloadEpisodes downloads up to count of the candidates, in order.  Downloads are
+// started in waves of at most count episodes and run concurrently, bounded by
+// config.Limiter.  Results are applied in order after each wave, so the episodes
+// marked Downloaded are the same as if they were fetched one at a time.
+// Downloads failing validation are moved to quarantineDirectory, failed downloads
+// are tried again after a backoff.  Episodes whose audio was Replaced are
+// downloaded over their file, which is kept if the download fails.  If pace is
+// set, episodes are downloaded one at a time with a pause of pace between them.
+func (podcast *Podcast) downloadEpisodes(ctx context.Context, config Config, client *HTTPClient, podcastDirectory string, quarantineDirectory string, candidates []*Episode, count int, pace time.Duration) {
+	limiter := config.Limiter
+	if limiter == nil {
+		limiter = NewLimiter(1, 0)
+	}
+	waveSize := count
+	if pace > 0 {
+		waveSize = 1
+	}
+	used := podcast.diskUsage(podcastDirectory)
+	for first := true; count > 0 && len(candidates) > 0 && ctx.Err() == nil; first = false {
+		if pace > 0 && !first {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pace):
+			}
+		}
+		// reserve the space of each download in the podcast's and the global budgets,
+		// downloads of unknown size are started on their own so the budget is up to date
+		wave := make([]*Episode, 0, min(count, waveSize, len(candidates)))
+		for len(wave) < min(count, waveSize) && len(candidates) > 0 {
+			episode := candidates[0]
+			unknownSize := episode.Length <= 0 && (podcast.MaxBytes > 0 || config.DiskBudget != nil)
+			if unknownSize && len(wave) > 0 {
+				break
+			}
+			if podcast.MaxBytes > 0 && used+episode.Length > int64(podcast.MaxBytes) {
+				log.Infof("%s: not downloading more episodes, they would exceed the %s limit", podcast.Label, podcast.MaxBytes)
+				count = 0
+				break
+			}
+			err := config.DiskBudget.Reserve(podcastDirectory, episode.Length)
+			if err != nil {
+				log.Infof("%s: not downloading more episodes, %v", podcast.Label, err)
+				count = 0
+				break
+			}
+			used += episode.Length
+			wave = append(wave, episode)
+			candidates = candidates[1:]
+			if unknownSize {
+				break
+			}
 		}
+
+		// the size of the files replaced audio is downloaded over
+		previous := make([]int64, len(wave))
+		for index, episode := range wave {
+			if episode.Replaced {
+				previous[index] = fileSize(path.Join(podcastDirectory, episode.Filename))
+			}
+		}
+		errs := make([]error, len(wave))
+		var wg sync.WaitGroup
+		for index, episode := range wave {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				release := limiter.Acquire(episode.URL)
+				defer release()
+				if ctx.Err() != nil {
+					errs[index] = ctx.Err()
+					return
+				}
+				log.Infof("downloading %s from %s", episode.Filename, episode.URL)
+				errs[index] = episode.Download(ctx, client, path.Join(podcastDirectory, episode.Filename))
+			}()
+		}
+		wg.Wait()
+
+		for index, episode := range wave {
+			var validationError *ValidationError
+			// replace the reserved size with the size downloaded
+			size := fileSize(path.Join(podcastDirectory, episode.Filename)) - previous[index]
+			if errs[index] != nil {
+				size = 0
+			}
+			used += size - episode.Length
+			config.DiskBudget.Adjust(size - episode.Length)
+			if errs[index] == nil {
+				if episode.Replaced {
+					episode.Replaced = false
+				} else {
+					err := episode.Transition(Downloaded, "downloaded")
+					if err != nil {
+						log.Error(err)
+					}
+				}
+				episode.ClearFailure()
+				count--
+				podcast.checkpoint(config, episode)
+			} else if ctx.Err() != nil {
+				log.Debugf("download of %s was cancelled", episode.Filename)
+			} else if errors.As(errs[index], &validationError) {
+				log.Errorf("episode %s from %s failed validation: %s", episode.Filename, episode.URL, validationError)
+				if episode.RecordFailure(validationError, validationError.Permanent(), config.MaxAttempts, config.RetryBackoff) {
+					log.Errorf("giving up on episode %s after %d attempts", episode.Filename, episode.Attempts)
+				}
+				quarantine(validationError.Path, quarantineDirectory)
+				podcast.checkpoint(config, episode)
+			} else {
+				log.Errorf("could not download episode %s from %s: %s", episode.Filename, episode.URL, errs[index])
+				if episode.RecordFailure(errs[index], false, config.MaxAttempts, config.RetryBackoff) {
+					log.Errorf("giving up on episode %s after %d attempts", episode.Filename, episode.Attempts)
+				} else {
+					log.Infof("will try episode %s again after %s", episode.Filename, episode.NextAttempt.Format(time.RFC1123))
+				}
+				podcast.checkpoint(config, episode)
+			}
+		}
+	}
+}
+
+// checkpoint reports a change to the episode's state through config.EpisodeCheckpoint.
+func (podcast *Podcast) checkpoint(config Config, episode *Episode) {
+	if config.EpisodeCheckpoint != nil {
+		config.EpisodeCheckpoint(podcast, episode)
+	}
+}
+
+// EpisodeKey returns the key of episode in the podcast's Episodes map.
+func (podcast *Podcast) EpisodeKey(episode *Episode) string {
+	for key, e := range podcast.Episodes {
+		if e == episode {
+			return key
+		}
+	}
+	return episode.GUID
+}
+
+// quarantine moves a rejected download into directory for inspection.
+func quarantine(path string, directory string) {
+	if path == "" {
+		return
+	}
+	os.MkdirAll(directory, 0755)
+	destination := filepath.Join(directory, strings.TrimSuffix(filepath.Base(path), PartialSuffix))
+	log.Warnf("moving %s to quarantine %s", path, destination)
+	err := os.Rename(path, destination)
+	if err != nil {
+		log.Errorf("could not quarantine %s: %v", path, err)
+		os.Remove(path)
 	}
-	return countOfExistingFiles
+	os.Remove(path + validatorSuffix)
 }
 
-func (podcast *Podcast) UpdateFromRSS(config Config) (*gofeed.Feed, error) {
-	log.Infof("fetching feed from %s", podcast.Feed)
+// CacheFile is where the last copy of the feed is kept, or "" if caching is disabled.
+func (podcast *Podcast) CacheFile(config Config, configFilePath string) string {
+	if config.CacheDirectory == "" {
+		return ""
+	}
+	re := regexp.MustCompile(`[^A-Za-z0-9_\-\.]`)
+	filename := re.ReplaceAllString(podcast.Label, "-") + ".xml"
+	return filepath.Join(resolvePath(configFilePath, config.CacheDirectory), filename)
+}
+
+// UpdateFromRSS fetches the feed and adds any new episodes.  The ETag and Last-Modified
+// values of the last fetch are sent back, if the feed has not changed nil is returned
+// without parsing.  When config.Offline is set, the cached copy of the feed is used.
+func (podcast *Podcast) UpdateFromRSS(ctx context.Context, config Config, configFilePath string) (*gofeed.Feed, error) {
+	if podcast.Episodes == nil {
+		podcast.Episodes = make(map[string]*Episode, 0)
+	}
+	cacheFile := podcast.CacheFile(config, configFilePath)
+	var body []byte
+	var header http.Header
+	var moved string
+	var err error
+	if config.Offline {
+		if cacheFile == "" {
+			return nil, fmt.Errorf("can not sync %s offline without a cache directory", podcast.Label)
+		}
+		log.Infof("reading cached feed from %s", cacheFile)
+		body, err = os.ReadFile(cacheFile)
+	} else {
+		log.Infof("fetching feed from %s", podcast.Feed)
+		var client *HTTPClient
+		client, err = podcast.HTTPClient(config, configFilePath)
+		if err == nil {
+			body, header, moved, err = podcast.fetchFeed(ctx, client, cacheFile)
+		}
+	}
+	if err != nil {
+		log.Errorf("could not fetch feed: %s", podcast.Feed)
+		log.Errorf("skipping podcast '%s'", podcast.Label)
+		return nil, err
+	}
+	if body == nil {
+		log.Infof("feed for %s has not changed", podcast.Label)
+		if moved != "" {
+			podcast.moveFeed(config, moved, "permanent redirect")
+		}
+		return nil, nil
+	}
+
 	// Load the podcast, figure out what's going on
 	fp := gofeed.NewParser()
-	feed, err := fp.ParseURL(podcast.Feed)
+	feed, err := fp.Parse(bytes.NewReader(body))
 	if err != nil {
 		log.Errorf("could not parse feed: %s", podcast.Feed)
 		log.Errorf("skipping podcast '%s'", podcast.Label)
 		return nil, err
 	}
+	if header != nil {
+		// only remember the validators once the feed is known to be good
+		podcast.ETag = header.Get("ETag")
+		podcast.LastModified = header.Get("Last-Modified")
+		if cacheFile != "" && !config.DryRun {
+			err = writeFileAtomic(cacheFile, body)
+			if err != nil {
+				log.Errorf("could not cache feed for %s: %v", podcast.Label, err)
+			}
+		}
+	}
+	if !config.Offline {
+		if moved != "" {
+			podcast.moveFeed(config, moved, "permanent redirect")
+		}
+		if feed.ITunesExt != nil && feed.ITunesExt.NewFeedURL != "" {
+			podcast.moveFeed(config, feed.ITunesExt.NewFeedURL, "itunes:new-feed-url")
+		}
+	}
 	log.Infof("synchronizing %s", feed.Title)
 	podcast.Title = feed.Title
 
 	// Update any new episodes
-	for _, item := range feed.Items {
+	seen := make(map[string]bool, len(feed.Items))
+	dates, inferred := podcast.inferDates(feed, header)
+	include, exclude := podcast.enclosureRules()
+	identities := podcast.identityIndex(podcast.Identity)
+	for index, item := range feed.Items {
 		// construct the episode
-		var audioFileURL string
-		for _, item := range item.Enclosures {
-			// TODO: Need to make sure it's an audio link
-			audioFileURL = item.URL
-		}
+		source, ok := chooseEnclosure(item, include, exclude)
 		if item.GUID == "" {
-			h := sha256.New()
-			h.Write([]byte(audioFileURL))
-			item.GUID = fmt.Sprintf("%x", h.Sum(nil))
+			// the last enclosure identifies the item, whichever source is chosen
+			guidURL := source.URL
+			if len(item.Enclosures) > 0 {
+				guidURL = item.Enclosures[len(item.Enclosures)-1].URL
+			}
+			item.GUID = hashURL(guidURL)
+		}
+		// do we have the episode, under its GUID or its identity?
+		key := item.GUID
+		identity := episodeIdentity(podcast.Identity, &Episode{
+			GUID:         item.GUID,
+			URL:          source.URL,
+			Title:        item.Title,
+			Date:         dates[index],
+			DateInferred: inferred[index],
+			PodcastGUID:  itemPodcastGUID(item),
+		})
+		if podcast.Episodes[key] == nil && identities[identity] != "" {
+			key = identities[identity]
+		}
+		seen[key] = true
+		if !ok {
+			log.Debugf("%s: %q has no enclosure matching the preference", podcast.Label, item.Title)
+			continue
 		}
 
-		// do we have the episode?
-		if podcast.Episodes[item.GUID] == nil {
+		if existing := podcast.Episodes[key]; existing != nil {
+			podcast.reconcileEpisode(config, existing, item, source, dates[index], inferred[index])
+		} else {
 
-			t, _ := time.Parse(time.RFC1123Z, item.Published)
 			episode := &Episode{
-				GUID:     item.GUID,
-				URL:      audioFileURL,
-				State:    New,
-				Title:    item.Title,
-				Filename: "",
-				Date:     t,
+				GUID:         item.GUID,
+				URL:          source.URL,
+				Type:         source.mediaType(),
+				State:        New,
+				Title:        item.Title,
+				Filename:     "",
+				Date:         dates[index],
+				DateInferred: inferred[index],
+				Length:       source.Length,
+				PodcastGUID:  itemPodcastGUID(item),
 			}
-			episode.Filename = podcast.FormatFilename(config.FilenameTemplate, episode, item)
+			episode.updateMetadata(item)
+			episode.Filename = podcast.episodeFilename(config, episode, item)
 			podcast.Episodes[item.GUID] = episode
+			identities[identity] = item.GUID
 		}
 	}
+	podcast.expireVanished(seen)
 	return feed, nil
 }
 
+// fetchFeed downloads the feed with a conditional GET, returning a nil body if the
+// feed has not been modified.  Validators are only sent when there is a cached
+// copy of the feed to fall back on, or no cache at all.
+func (podcast *Podcast) fetchFeed(ctx context.Context, client *HTTPClient, cacheFile string) ([]byte, http.Header, string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, podcast.Feed, nil)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if cacheFile == "" || IsFileExist(cacheFile) {
+		if podcast.ETag != "" {
+			request.Header.Set("If-None-Match", podcast.ETag)
+		}
+		if podcast.LastModified != "" {
+			request.Header.Set("If-Modified-Since", podcast.LastModified)
+		}
+	}
+	resp, err := client.Do(request)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	defer resp.Body.Close()
+	moved := permanentRedirect(resp)
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, resp.Header, moved, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, "", fmt.Errorf("server returned %s for %s", resp.Status, podcast.Feed)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	return body, resp.Header, moved, nil
+}
+
 func (podcast *Podcast) FormatFilename(filenameTemplate string, episode *Episode, item *gofeed.Item) string {
 	tmpl, err := template.New("filenameTemplate").Parse(filenameTemplate)
 	if err != nil {
@@ -209,21 +747,121 @@ func (podcast *Podcast) FormatFilename(filenameTemplate string, episode *Episode
 	return fn
 }
 
+// Clone returns a copy of the podcast and its episodes, so the copy's state can
+// be changed without affecting the original.
+func (podcast *Podcast) Clone() *Podcast {
+	clone := *podcast
+	clone.Tags = append([]string(nil), podcast.Tags...)
+	clone.Queue = append([]string(nil), podcast.Queue...)
+	clone.FeedHistory = append([]FeedMove(nil), podcast.FeedHistory...)
+	clone.Episodes = make(map[string]*Episode, len(podcast.Episodes))
+	for key, episode := range podcast.Episodes {
+		e := *episode
+		e.History = append([]StateChange(nil), episode.History...)
+		clone.Episodes[key] = &e
+	}
+	return &clone
+}
+
+// HasTag returns true if the podcast is tagged with tag.
+func (podcast *Podcast) HasTag(tag string) bool {
+	for _, t := range podcast.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// AddTags tags the podcast, ignoring tags it already has.
+func (podcast *Podcast) AddTags(tags ...string) {
+	for _, tag := range tags {
+		if tag != "" && !podcast.HasTag(tag) {
+			podcast.Tags = append(podcast.Tags, tag)
+		}
+	}
+}
+
+// RemoveTags removes the tags from the podcast.
+func (podcast *Podcast) RemoveTags(tags ...string) {
+	kept := make([]string, 0, len(podcast.Tags))
+	for _, t := range podcast.Tags {
+		remove := false
+		for _, tag := range tags {
+			remove = remove || t == tag
+		}
+		if !remove {
+			kept = append(kept, t)
+		}
+	}
+	podcast.Tags = kept
+}
+
 func (podcast *Podcast) PrintDetails() string {
 	buffer := bytes.NewBufferString("")
 	fmt.Fprintf(buffer, "Title: %s\n", podcast.Title)
 	fmt.Fprintf(buffer, "Label: %s\nFeed: %s\nDirection: %s\nNumber of Episodes: %d\n",
 		podcast.Label, podcast.Feed, podcast.Start, len(podcast.Episodes))
+	if len(podcast.Tags) > 0 {
+		fmt.Fprintf(buffer, "Tags: %s\n", strings.Join(podcast.Tags, ", "))
+	}
+	if podcast.IsManual() {
+		fmt.Fprintf(buffer, "Mode: %s, %d queued\n", podcast.Mode, len(podcast.QueuedEpisodes()))
+	}
+	if podcast.IsArchive() {
+		fmt.Fprintf(buffer, "Mode: %s, %d episodes in the backlog\n", podcast.Mode, podcast.GetNewCount())
+	}
+	var duration time.Duration
+	for _, episode := range podcast.Episodes {
+		if episode.OnDisk() {
+			duration += episode.Duration
+		}
+	}
+	if duration > 0 {
+		fmt.Fprintf(buffer, "Duration on disk: %s\n", duration.Round(time.Minute))
+	}
 	countOfDownloaded := podcast.GetDownloadedCount()
 	countOfNew := podcast.GetNewCount()
 	countOfDeleted := podcast.GetDeletedCount()
 	fmt.Fprintf(buffer, "\tDownloaded: %d\n", countOfDownloaded)
 	fmt.Fprintf(buffer, "\tNew: %d\n", countOfNew)
 	fmt.Fprintf(buffer, "\tDeleted: %d\n", countOfDeleted)
+	// the newer states are only shown when they are used
+	for _, state := range []EpisodeState{Failed, Skipped, Pinned, Expired, Played} {
+		count := podcast.GetStateCount(state)
+		if count > 0 {
+			fmt.Fprintf(buffer, "\t%s: %d\n", strings.ToUpper(state.String()[:1])+state.String()[1:], count)
+		}
+	}
+	for _, episode := range podcast.OrderedEpisodes() {
+		if episode.LastError == "" || (episode.State != New && episode.State != Failed) {
+			continue
+		}
+		fmt.Fprintf(buffer, "\t\t%s: %s after %d attempts", episode.Title, episode.State, episode.Attempts)
+		if episode.LastStatus != 0 {
+			fmt.Fprintf(buffer, ", status %d", episode.LastStatus)
+		}
+		fmt.Fprintf(buffer, ", last error: %s", episode.LastError)
+		if episode.State == New {
+			fmt.Fprintf(buffer, ", next attempt %s", episode.NextAttempt.Format(time.RFC1123))
+		}
+		fmt.Fprintf(buffer, "\n")
+	}
 	fmt.Fprintf(buffer, "\n")
 	return buffer.String()
 }
 
+// GetStateCount returns the number of episodes in state.
+func (podcast *Podcast) GetStateCount(state EpisodeState) int {
+	counter := 0
+	for _, episode := range podcast.Episodes {
+		if episode.State == state {
+			counter++
+		}
+	}
+	return counter
+}
+
 func (podcast *Podcast) GetNewCount() int {
 	counter := 0
 	for _, episode := range podcast.Episodes {
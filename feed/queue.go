@@ -0,0 +1,106 @@
+package feed
+
+import (
+	"fmt"
+)
+
+const (
+	// ModeAuto downloads new episodes in order up to CountToKeep
+	ModeAuto = "auto"
+	// ModeManual only downloads the episodes in the podcast's Queue
+	ModeManual = "manual"
+	// ModeArchive downloads every episode, a batch each sync, and keeps them
+	ModeArchive = "archive"
+)
+
+// ValidateMode returns an error if mode is not a podcast mode, an empty mode is ModeAuto.
+func ValidateMode(mode string) error {
+	if mode != "" && mode != ModeAuto && mode != ModeManual && mode != ModeArchive {
+		return fmt.Errorf("unknown mode %q, expected %s, %s or %s", mode, ModeAuto, ModeManual, ModeArchive)
+	}
+	return nil
+}
+
+// IsManual returns true if only queued episodes are downloaded.
+func (podcast *Podcast) IsManual() bool {
+	return podcast.Mode == ModeManual
+}
+
+// IsArchive returns true if every episode is downloaded and kept.
+func (podcast *Podcast) IsArchive() bool {
+	return podcast.Mode == ModeArchive
+}
+
+// Prunes returns true if the podcast's oldest downloads are removed to make room for newer episodes.
+func (podcast *Podcast) Prunes() bool {
+	return podcast.Prune && !podcast.IsManual() && !podcast.IsArchive()
+}
+
+// QueuedEpisodes returns the queued episodes in queue order, GUIDs of episodes
+// no longer in the podcast are ignored.
+func (podcast *Podcast) QueuedEpisodes() []*Episode {
+	queued := make([]*Episode, 0, len(podcast.Queue))
+	for _, guid := range podcast.Queue {
+		for _, episode := range podcast.Episodes {
+			if episode.GUID == guid {
+				queued = append(queued, episode)
+				break
+			}
+		}
+	}
+	return queued
+}
+
+// IsQueued returns true if the episode is in the queue.
+func (podcast *Podcast) IsQueued(episode *Episode) bool {
+	return contains(podcast.Queue, episode.GUID)
+}
+
+// Enqueue adds the episode to the end of the queue, or the front if front is set.
+// Episodes not on disk are marked New so sync downloads them.  Queuing an episode
+// already in the queue moves it.
+func (podcast *Podcast) Enqueue(episode *Episode, front bool) error {
+	if !episode.OnDisk() && episode.State != New {
+		err := episode.Transition(New, "queued")
+		if err != nil {
+			return err
+		}
+		episode.ClearFailure()
+	}
+	podcast.Dequeue(episode)
+	if front {
+		podcast.Queue = append([]string{episode.GUID}, podcast.Queue...)
+	} else {
+		podcast.Queue = append(podcast.Queue, episode.GUID)
+	}
+	return nil
+}
+
+// Dequeue removes the episode from the queue and returns true if it was queued.
+func (podcast *Podcast) Dequeue(episode *Episode) bool {
+	queue := make([]string, 0, len(podcast.Queue))
+	for _, guid := range podcast.Queue {
+		if guid != episode.GUID {
+			queue = append(queue, guid)
+		}
+	}
+	removed := len(queue) != len(podcast.Queue)
+	podcast.Queue = queue
+	return removed
+}
+
+// PlaylistEpisodes orders the episodes for the playlist, the queue first in a
+// manual podcast and then the download order.
+func (podcast *Podcast) PlaylistEpisodes() []*Episode {
+	ordered := podcast.OrderedEpisodes()
+	if !podcast.IsManual() {
+		return ordered
+	}
+	playlist := podcast.QueuedEpisodes()
+	for _, episode := range ordered {
+		if !podcast.IsQueued(episode) {
+			playlist = append(playlist, episode)
+		}
+	}
+	return playlist
+}
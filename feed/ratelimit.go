@@ -0,0 +1,75 @@
+package feed
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter limits the number of bytes per second read through it.  One limiter
+// may be shared by many downloads, which then share the bandwidth.
+type RateLimiter struct {
+	rate   float64
+	mutex  sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// rateLimitChunk bounds each read, so the limit is applied smoothly
+const rateLimitChunk = 16 * 1024
+
+// NewRateLimiter returns a limiter for bytesPerSecond, or nil if there is no limit.
+func NewRateLimiter(bytesPerSecond ByteSize) *RateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &RateLimiter{rate: float64(bytesPerSecond), last: time.Now()}
+}
+
+// Wait blocks until n more bytes may be read.
+func (r *RateLimiter) Wait(ctx context.Context, n int) error {
+	if r == nil || n <= 0 {
+		return nil
+	}
+	r.mutex.Lock()
+	now := time.Now()
+	// allow at most one second of burst
+	r.tokens = min(r.tokens+now.Sub(r.last).Seconds()*r.rate, r.rate)
+	r.last = now
+	r.tokens -= float64(n)
+	wait := time.Duration(-r.tokens / r.rate * float64(time.Second))
+	r.mutex.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimitedReader reads through every non nil limiter
+type rateLimitedReader struct {
+	ctx      context.Context
+	reader   io.Reader
+	limiters []*RateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if len(p) > rateLimitChunk {
+		p = p[:rateLimitChunk]
+	}
+	n, err := r.reader.Read(p)
+	for _, limiter := range r.limiters {
+		waitErr := limiter.Wait(r.ctx, n)
+		if waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
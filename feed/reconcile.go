@@ -0,0 +1,115 @@
+package feed
+
+import (
+	"fmt"
+	"github.com/mmcdole/gofeed"
+	log "github.com/sirupsen/logrus"
+	"math"
+	"time"
+)
+
+// replacedLengthChange is the change of an episode's enclosure length, as a fraction
+// of the old length, that means its audio was replaced.  Smaller changes are
+// usually dynamically inserted ads.
+const replacedLengthChange = 0.2
+
+const (
+	// ReplacedAudioKeep keeps the downloaded file when the feed replaces an episode's audio
+	ReplacedAudioKeep = "keep"
+	// ReplacedAudioRedownload downloads the episode again when the feed replaces its audio
+	ReplacedAudioRedownload = "redownload"
+)
+
+// ValidateReplacedAudio returns an error if policy is not a replaced audio policy,
+// an empty policy is ReplacedAudioKeep.
+func ValidateReplacedAudio(policy string) error {
+	if policy != "" && policy != ReplacedAudioKeep && policy != ReplacedAudioRedownload {
+		return fmt.Errorf("unknown replaced audio policy %q, expected %s or %s", policy, ReplacedAudioKeep, ReplacedAudioRedownload)
+	}
+	return nil
+}
+
+// reconcileEpisode updates an episode from its item in the feed.  A new enclosure
+// URL, or a length more than replacedLengthChange different, means the audio was
+// replaced, a downloaded episode is flagged to be downloaded again if the
+// podcast's ReplacedAudio policy asks for it.  Expired episodes back in the feed
+// are New again.
+func (podcast *Podcast) reconcileEpisode(config Config, episode *Episode, item *gofeed.Item, source enclosureSource, date time.Time, inferred bool) {
+	if episode.State == Expired {
+		err := episode.Transition(New, "back in the feed")
+		if err != nil {
+			log.Error(err)
+		}
+	}
+	episode.updateMetadata(item)
+	rename := false
+	if item.Title != "" && item.Title != episode.Title {
+		log.Infof("%s: episode %q is now titled %q", podcast.Label, episode.Title, item.Title)
+		episode.Title = item.Title
+		rename = true
+	}
+	// replace a missing or inferred date once the feed has a real one
+	if (episode.Date.IsZero() || episode.DateInferred) && !inferred {
+		log.Infof("%s: the date of %q is %s", podcast.Label, episode.Title, date.Format(time.RFC1123Z))
+		episode.Date = date
+		episode.DateInferred = false
+		rename = true
+	}
+	if rename && episode.State == New {
+		// nothing is on disk yet, so the filename can follow the title and date
+		episode.Filename = podcast.episodeFilename(config, episode, item)
+	}
+	url, length := source.URL, source.Length
+	episode.Type = source.mediaType()
+	if guid := itemPodcastGUID(item); guid != "" {
+		episode.PodcastGUID = guid
+	}
+	replaced := false
+	if url != "" && url != episode.URL && podcast.Identity == IdentityURL && normalizeURL(url) == normalizeURL(episode.URL) {
+		// only tracking parameters changed, the audio is the same
+		log.Debugf("%s: the URL of %q is now %s", podcast.Label, episode.Title, url)
+		episode.URL = url
+	}
+	if url != "" && url != episode.URL {
+		log.Infof("%s: the URL of %q changed from %s to %s", podcast.Label, episode.Title, episode.URL, url)
+		episode.URL = url
+		replaced = true
+	}
+	if length > 0 && episode.Length > 0 && length != episode.Length {
+		change := math.Abs(float64(length-episode.Length)) / float64(episode.Length)
+		if change > replacedLengthChange {
+			log.Infof("%s: the length of %q changed from %d to %d", podcast.Label, episode.Title, episode.Length, length)
+			replaced = true
+		} else {
+			log.Debugf("%s: the length of %q changed from %d to %d", podcast.Label, episode.Title, episode.Length, length)
+		}
+	}
+	if length > 0 {
+		episode.Length = length
+	}
+	if replaced && episode.OnDisk() && podcast.ReplacedAudio == ReplacedAudioRedownload {
+		log.Infof("%s: the audio of %q was replaced, downloading it again", podcast.Label, episode.Title)
+		episode.Replaced = true
+	}
+}
+
+// expireVanished marks episodes that are no longer in the feed, and were never
+// downloaded, Expired.  Nothing is expired if the feed is empty, it is more
+// likely to be broken than to have removed every episode.
+func (podcast *Podcast) expireVanished(seen map[string]bool) {
+	if len(seen) == 0 {
+		return
+	}
+	for key, episode := range podcast.Episodes {
+		if seen[key] || seen[episode.GUID] {
+			continue
+		}
+		if episode.State == New || episode.State == Failed || episode.State == Skipped {
+			log.Infof("%s: %q is no longer in the feed", podcast.Label, episode.Title)
+			err := episode.Transition(Expired, "no longer in the feed")
+			if err != nil {
+				log.Error(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package feed
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// EpisodeSelector chooses episodes of a podcast.  An episode is selected if it
+// matches every criteria that is set.
+type EpisodeSelector struct {
+	// Since and Until bound the episode's date, Until is exclusive.  Zero values are not checked.
+	Since time.Time
+	Until time.Time
+	// Title matches the episode's title
+	Title *regexp.Regexp
+	// IDs selects episodes by GUID or short ID
+	IDs []string
+	// States selects episodes in one of the states
+	States []EpisodeState
+	// All selects every episode, it must be set if no other criteria is
+	All bool
+}
+
+// IsEmpty returns true if the selector has no criteria and All is not set.
+func (selector EpisodeSelector) IsEmpty() bool {
+	return !selector.All && selector.Since.IsZero() && selector.Until.IsZero() &&
+		selector.Title == nil && len(selector.IDs) == 0 && len(selector.States) == 0
+}
+
+// Matches returns true if the episode is selected.
+func (selector EpisodeSelector) Matches(episode *Episode) bool {
+	if selector.IsEmpty() {
+		return false
+	}
+	if !selector.Since.IsZero() && episode.Date.Before(selector.Since) {
+		return false
+	}
+	if !selector.Until.IsZero() && !episode.Date.Before(selector.Until) {
+		return false
+	}
+	if selector.Title != nil && !selector.Title.MatchString(episode.Title) {
+		return false
+	}
+	if len(selector.IDs) > 0 && !hasAnyID(episode, selector.IDs) {
+		return false
+	}
+	if len(selector.States) > 0 && !contains(selector.States, episode.State) {
+		return false
+	}
+	return true
+}
+
+// SelectEpisodes returns the selected episodes in download order.
+func (podcast *Podcast) SelectEpisodes(selector EpisodeSelector) []*Episode {
+	selected := make([]*Episode, 0)
+	for _, episode := range podcast.OrderedEpisodes() {
+		if selector.Matches(episode) {
+			selected = append(selected, episode)
+		}
+	}
+	return selected
+}
+
+// ParseSelectorDate parses a date for a selector, either 2006-01-02 in the local
+// time zone or RFC 3339.  A date without a time is the start of the day, or the
+// start of the next day if endOfDay is set so that an Until date is inclusive.
+func ParseSelectorDate(value string, endOfDay bool) (time.Time, error) {
+	date, err := time.ParseInLocation(time.DateOnly, value, time.Local)
+	if err == nil {
+		if endOfDay {
+			date = date.AddDate(0, 0, 1)
+		}
+		return date, nil
+	}
+	date, err = time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not parse date %q, expected 2006-01-02 or RFC 3339", value)
+	}
+	return date, nil
+}
+
+func hasAnyID(episode *Episode, ids []string) bool {
+	for _, id := range ids {
+		if episode.HasID(id) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains[T comparable](values []T, value T) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package feed
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ValidationError reports a download that is not a usable audio file, for instance
+// a 404 or the HTML page of a captive portal.  Episodes failing validation are
+// tried again on later syncs, like other failures, unless the failure is Permanent.
+type ValidationError struct {
+	Reason     string
+	StatusCode int
+	// Path is the rejected file, if anything was written
+	Path string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Reason
+}
+
+// Permanent returns true if the server said the episode is gone for good, other
+// failures, even a 404 or an HTML page, may be a broken host or a captive portal.
+func (e *ValidationError) Permanent() bool {
+	return e.StatusCode == http.StatusGone
+}
+
+// acceptedContentTypes are the non audio/video media types that may hold an episode
+var acceptedContentTypes = []string{
+	"application/octet-stream",
+	"binary/octet-stream",
+	"application/mp4",
+	"application/ogg",
+}
+
+// validContentType returns true if a response with this Content-Type may contain audio.
+// An empty Content-Type is accepted and left to the magic number check.
+func validContentType(contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if strings.HasPrefix(mediaType, "audio/") || strings.HasPrefix(mediaType, "video/") {
+		return true
+	}
+	for _, accepted := range acceptedContentTypes {
+		if mediaType == accepted {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAudioMagic returns true if header starts like an MP3 (ID3 tag or MPEG frame sync),
+// an MP4/M4A (ftyp box), an Ogg or a FLAC file.
+func hasAudioMagic(header []byte) bool {
+	switch {
+	case bytes.HasPrefix(header, []byte("ID3")):
+		return true
+	case len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0:
+		return true
+	case len(header) >= 8 && bytes.Equal(header[4:8], []byte("ftyp")):
+		return true
+	case bytes.HasPrefix(header, []byte("OggS")), bytes.HasPrefix(header, []byte("fLaC")):
+		return true
+	}
+	return false
+}
+
+// validateDownload checks a completed download against the response Content-Type,
+// the audio magic numbers and the enclosure length advertised in the feed.
+// Advertised lengths are often stale, so only files shorter than 90% of the length
+// are rejected.  Dynamically inserted ads commonly make files longer.
+func validateDownload(path string, contentType string, length int64) error {
+	if !validContentType(contentType) {
+		return &ValidationError{Reason: fmt.Sprintf("unexpected content type %q", contentType), Path: path}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	header := make([]byte, 12)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+	if !hasAudioMagic(header[:n]) {
+		return &ValidationError{Reason: "file does not look like audio", Path: path}
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if length > 0 && info.Size() < length*9/10 {
+		return &ValidationError{Reason: fmt.Sprintf("file is %d bytes but the feed advertised %d", info.Size(), length), Path: path}
+	}
+	return nil
+}
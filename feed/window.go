@@ -0,0 +1,44 @@
+package feed
+
+import (
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// parseWindow parses a daily window such as "01:00-06:00" into offsets from midnight.
+func parseWindow(window string) (time.Duration, time.Duration, error) {
+	var startHour, startMinute, endHour, endMinute int
+	_, err := fmt.Sscanf(window, "%d:%d-%d:%d", &startHour, &startMinute, &endHour, &endMinute)
+	if err != nil || startHour > 24 || endHour > 24 || startMinute > 59 || endMinute > 59 ||
+		startHour < 0 || endHour < 0 || startMinute < 0 || endMinute < 0 {
+		return 0, 0, fmt.Errorf("invalid download window %q, expected HH:MM-HH:MM", window)
+	}
+	start := time.Duration(startHour)*time.Hour + time.Duration(startMinute)*time.Minute
+	end := time.Duration(endHour)*time.Hour + time.Duration(endMinute)*time.Minute
+	return start, end, nil
+}
+
+// InDownloadWindow returns true if now, in local time, falls in one of the windows.
+// Windows ending before they start wrap past midnight, "22:00-06:00" is overnight.
+// With no valid windows downloads are always allowed.
+func InDownloadWindow(windows []string, now time.Time) bool {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	offset := now.Sub(midnight)
+	valid := 0
+	for _, window := range windows {
+		start, end, err := parseWindow(window)
+		if err != nil {
+			log.Errorf("ignoring %v", err)
+			continue
+		}
+		valid++
+		if start <= end && offset >= start && offset < end {
+			return true
+		}
+		if start > end && (offset >= start || offset < end) {
+			return true
+		}
+	}
+	return valid == 0
+}